@@ -76,11 +76,23 @@ Examples:
 		if err != nil {
 			logger.Fatalf("Failed to load config: %v", err)
 		}
+		if err := config.UseProfile(""); err != nil {
+			logger.Fatalf("Failed to load config: %v", err)
+		}
+		tokenErr := configRepo.ResolveAccessToken(config)
 
 		fmt.Printf("Configuration file: %s\n", configRepo.GetConfigPath())
+		if projectPath := configRepo.GetProjectPath(); projectPath != "" {
+			fmt.Printf("Project file: %s\n", projectPath)
+		}
+		if config.CurrentProfile != "" {
+			fmt.Printf("Profile: %s\n", config.CurrentProfile)
+		}
 		fmt.Printf("Server URL: %s\n", config.ServerURL)
-		if config.AccessToken != "" {
-			fmt.Printf("Access Token: %s...\n", config.AccessToken[:min(len(config.AccessToken), 10)])
+		if tokenErr != nil {
+			fmt.Printf("Access Token: (error: %v)\n", tokenErr)
+		} else if config.AccessToken != "" {
+			fmt.Printf("Access Token: %s... (from %s)\n", config.AccessToken[:min(len(config.AccessToken), 10)], config.TokenSource)
 		} else {
 			fmt.Println("Access Token: (not set)")
 		}
@@ -91,5 +103,5 @@ func init() {
 	configCmd.Flags().BoolVarP(&showConfig, "show", "s", false, "Show current configuration")
 	configCmd.Flags().StringVarP(&setURL, "set-url", "u", "", "Set the server WebSocket URL")
 	configCmd.Flags().StringVarP(&setToken, "set-token", "t", "", "Set the access token")
-	// TODO: set a config file directly e.g .gtunnle file
+	configCmd.AddCommand(configValidateCmd)
 }
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client"
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/client/repositories"
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Validate the client config and project file",
+	Long: `Check config files for unknown fields, wrong value types, invalid durations and
+invalid tunnel settings, and report each problem with its line number.
+
+Without a file, the user config and the project file in use (--config or the closest
+.gtunnel.yaml) are validated.
+
+Examples:
+  gtc config validate                  # Validate the user config and project file
+  gtc config validate .gtunnel.yaml    # Validate a specific file`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var files []string
+		if len(args) == 1 {
+			files = append(files, args[0])
+		} else {
+			configRepo := repositories.NewClientConfigRepo()
+			if _, err := os.Stat(configRepo.GetConfigPath()); err == nil {
+				files = append(files, configRepo.GetConfigPath())
+			}
+			if projectFile != "" {
+				files = append(files, projectFile)
+			} else if wd, err := os.Getwd(); err == nil {
+				if path := repositories.FindProjectFile(wd); path != "" {
+					files = append(files, path)
+				}
+			}
+		}
+		if len(files) == 0 {
+			fmt.Println("No config file to validate")
+			return
+		}
+
+		checks := map[string]repositories.ValueCheck{
+			"tunnels.*.upstream": func(value string) error {
+				_, err := client.ParseUpstream(value)
+				return err
+			},
+			"tunnels.*.tls.ca_file": checkFileExists,
+			"tunnels.*.allow_cidrs": checkCIDR,
+			"tunnels.*.deny_cidrs":  checkCIDR,
+			"tunnels.*.basic_auth": func(value string) error {
+				_, err := models.ParseBasicAuth([]string{value})
+				return err
+			},
+			"tunnels.*.rate_limit.rate": func(value string) error {
+				_, _, err := protocol.ParseRate(value)
+				return err
+			},
+			"tunnels.*.cors.mode": func(value string) error {
+				if value == "" || value == protocol.CORSModePolicy {
+					return nil // needs the origins, checked when the tunnel starts
+				}
+				return protocol.ValidateCORS(protocol.CORSPolicy{Mode: value})
+			},
+			"tunnels.*.path.mode": func(value string) error {
+				if value == protocol.PathModeRewrite {
+					return nil // needs the rules, checked when the tunnel starts
+				}
+				return protocol.ValidatePathOptions(protocol.PathOptions{Mode: value})
+			},
+			"profiles.*.tls.ca_file": checkFileExists,
+			"server_tls.ca_file":     checkFileExists,
+			"token_file":             checkTokenFile,
+			"profiles.*.token_file":  checkTokenFile,
+		}
+
+		problems := 0
+		for _, file := range files {
+			issues, err := repositories.ValidateConfigFile(file, checks)
+			if err != nil {
+				logger.Fatalf("Failed to validate %s: %v", file, err)
+			}
+			if len(issues) == 0 {
+				color.Green("✓ %s is valid", file)
+				continue
+			}
+			for _, issue := range issues {
+				color.Red("✗ %s", issue)
+			}
+			problems += len(issues)
+		}
+
+		if problems > 0 {
+			fmt.Printf("\n%d problem(s) found\n", problems)
+			os.Exit(1)
+		}
+	},
+}
+
+func checkCIDR(value string) error {
+	return models.ValidateCIDRs([]string{value})
+}
+
+func checkTokenFile(path string) error {
+	return checkFileExists(repositories.ExpandHome(path))
+}
+
+func checkFileExists(path string) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("file %q not found", path)
+	}
+	return nil
+}
@@ -3,17 +3,60 @@ package cmd
 import (
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/B-AJ-Amar/gTunnel/internal/client"
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
 	"github.com/B-AJ-Amar/gTunnel/internal/client/repositories"
+	"github.com/B-AJ-Amar/gTunnel/internal/har"
 	"github.com/B-AJ-Amar/gTunnel/internal/logger"
 	"github.com/spf13/cobra"
 )
 
 var (
-	serverURL string
-	baseURL   string
-	debug     bool
+	serverURL       string
+	baseURL         string
+	debug           bool
+	upstreamTimeout time.Duration
+	pingInterval    time.Duration
+	responseTimeout time.Duration
+
+	upstreamInsecure bool
+	upstreamCA       string
+	upstreamSNI      string
+	hostHeader       string
+	noForwarded      bool
+
+	inspectAddr string
+	noInspect   bool
+
+	harFile   string
+	serverHAR bool
+
+	profileName string
+
+	basicAuth []string
+	allowCIDR []string
+	denyCIDR  []string
+
+	oidcLogin        bool
+	oidcEmailDomains []string
+	oidcGroups       []string
+
+	rateLimit string
+	rateBurst int
+
+	corsMode        string
+	corsOrigins     []string
+	corsCredentials bool
+
+	requestHeaders  []string
+	responseHeaders []string
+
+	pathMode          string
+	pathRewrites      []string
+	rewriteLocation   bool
+	rewriteCookiePath bool
 )
 
 // buildWebSocketURL constructs the complete WebSocket URL with endpoint
@@ -42,13 +85,14 @@ func buildWebSocketURL(serverURL string) (string, error) {
 }
 
 var connectCmd = &cobra.Command{
-	Use:   "connect <port|host:port>",
+	Use:   "connect <port|host:port|url>",
 	Short: "Connect to a gTunnel server",
 	Long: `Connect to a gTunnel server and tunnel traffic to a local service.
 
-You can specify the tunnel target in two ways:
+You can specify the tunnel target in three ways:
   - Port only: connect 3000 (defaults to localhost:3000)
   - Host and port: connect myapp.local:3000
+  - Full URL: connect https://localhost:8443/api (scheme, host, port and path prefix)
 
 The server URL is loaded from configuration. Use 'gtc config --set-url <url>' to set it.
 The WebSocket endpoint (/___gTl___/ws) is automatically appended.
@@ -57,6 +101,7 @@ For HTTPS URLs, port 443 is automatically used if no port is specified.
 Examples:
   gtc connect 3000                                              # Tunnel to localhost:3000
   gtc connect api.example.com:8080                              # Tunnel to api.example.com:8080
+  gtc connect https://localhost:8443 --upstream-insecure        # Tunnel to a local HTTPS service with a self-signed cert
   gtc connect -u https://example.com 3000                       # Uses port 443 automatically
   gtc connect -u example.com:9000 3000                          # Override server URL for this connection`,
 	Args: cobra.ExactArgs(1),
@@ -64,58 +109,86 @@ Examples:
 		// Show banner
 		logger.ShowBanner("client")
 
-		// Initialize logger with debug level if debug flag is set
-		logLevel := logger.LevelInfo
-		if debug {
-			logLevel = logger.LevelDebug
-		}
-		logger.Init(logLevel, true)
-
 		target := args[0]
 
-		configRepo := repositories.NewClientConfigRepo()
-		if err := configRepo.InitConfig(); err != nil {
-			logger.Fatalf("Failed to initialize config: %v", err)
-		}
+		config := loadClientConfig()
+		setupLogging(cmd, config)
+		u := resolveWebSocketURL(config)
 
-		var finalServerURL string
-		if serverURL != "" {
-			finalServerURL = serverURL
-		} else {
-			config, err := configRepo.Load()
-			if err != nil {
-				logger.Fatalf("Failed to load config: %v", err)
-			}
-			finalServerURL = config.ServerURL
-			if finalServerURL == "" {
-				logger.Fatal("No server URL provided. Use --server-url flag or set it in config with 'gtc config --set-url <url>'")
-			}
+		upstream, err := client.ParseUpstream(target)
+		if err != nil {
+			logger.Fatalf("Invalid tunnel target: %v", err)
 		}
-
-		// Build the complete WebSocket URL with endpoint
-		wsURL, err := buildWebSocketURL(finalServerURL)
+		requestRules, err := client.ParseHeaderRules(requestHeaders)
 		if err != nil {
-			logger.Fatalf("Failed to build WebSocket URL: %v", err)
+			logger.Fatalf("Invalid --request-header: %v", err)
 		}
-
-		var tunnelHost, tunnelPort string
-		if strings.Contains(target, ":") {
-			parts := strings.SplitN(target, ":", 2)
-			tunnelHost = parts[0]
-			tunnelPort = parts[1]
-		} else {
-			tunnelHost = "localhost"
-			tunnelPort = target
+		responseRules, err := client.ParseHeaderRules(responseHeaders)
+		if err != nil {
+			logger.Fatalf("Invalid --response-header: %v", err)
+		}
+		rewrites, err := client.ParsePathRewrites(pathRewrites)
+		if err != nil {
+			logger.Fatalf("Invalid --path-rewrite: %v", err)
 		}
 
-		logger.Infof("Tunneling %s:%s ...", tunnelHost, tunnelPort)
+		timeouts := config.Timeouts
+		if cmd.Flags().Changed("upstream-timeout") {
+			timeouts.Upstream = upstreamTimeout
+		}
+		if cmd.Flags().Changed("ping-interval") {
+			timeouts.Ping = pingInterval
+		}
+		if cmd.Flags().Changed("response-timeout") {
+			timeouts.Response = responseTimeout
+		}
 
-		u, err := url.Parse("wss://" + wsURL)
-		if err != nil {
-			logger.Fatalf("Failed to parse WebSocket URL: %v", err)
+		tunnelConfig := models.TunnelConfig{
+			Upstream: target,
+			BaseURL:  baseURL,
+			TLS: models.TLSConfig{
+				InsecureSkipVerify: upstreamInsecure,
+				CAFile:             upstreamCA,
+				ServerName:         upstreamSNI,
+			},
+			HostHeader:              hostHeader,
+			DisableForwardedHeaders: noForwarded,
+			BasicAuth:               basicAuth,
+			AllowCIDRs:              allowCIDR,
+			DenyCIDRs:               denyCIDR,
+			HAR:                     harFile,
+			ServerHAR:               serverHAR,
+			RateLimit: models.RateLimit{
+				Rate:  rateLimit,
+				Burst: rateBurst,
+			},
+			OIDC: models.OIDCAccess{
+				Enabled:      oidcLogin,
+				EmailDomains: oidcEmailDomains,
+				Groups:       oidcGroups,
+			},
+			CORS: models.CORSConfig{
+				Mode:             corsMode,
+				AllowOrigins:     corsOrigins,
+				AllowCredentials: corsCredentials,
+			},
+			Headers: models.HeaderRules{Request: requestRules, Response: responseRules},
+			Path: models.PathConfig{
+				Mode:              pathMode,
+				Rewrites:          rewrites,
+				RewriteLocation:   rewriteLocation,
+				RewriteCookiePath: rewriteCookiePath,
+			},
 		}
+		if err := tunnelConfig.Validate(); err != nil {
+			logger.Fatalf("Invalid tunnel options: %v", err)
+		}
+
+		logger.Infof("Tunneling %s ...", upstream.String())
 
-		client.StartClient(*u, tunnelHost, tunnelPort, baseURL)
+		opts := clientOptions(cmd, config)
+		opts.Timeouts = timeouts
+		client.StartClient(*u, tunnelConfig, opts)
 	},
 }
 
@@ -123,4 +196,118 @@ func init() {
 	connectCmd.Flags().StringVarP(&serverURL, "server-url", "u", "", "Server URL (without WebSocket endpoint, e.g., example.com:443)")
 	connectCmd.Flags().StringVarP(&baseURL, "base-endpoint", "e", "", "Base endpoint path to route the tunneled app")
 	connectCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging")
+	connectCmd.Flags().DurationVar(&upstreamTimeout, "upstream-timeout", 0, "Timeout for requests to the local service (defaults to the tunnel response timeout)")
+	connectCmd.Flags().DurationVar(&pingInterval, "ping-interval", models.DefaultPingInterval, "Interval between keepalive pings to the server")
+	connectCmd.Flags().DurationVar(&responseTimeout, "response-timeout", 0, "Response timeout to request from the server for this tunnel (e.g. 2m)")
+	connectCmd.Flags().BoolVar(&upstreamInsecure, "upstream-insecure", false, "Skip TLS certificate verification for an https:// local service")
+	connectCmd.Flags().StringVar(&upstreamCA, "upstream-ca", "", "PEM file with a CA to trust for an https:// local service")
+	connectCmd.Flags().StringVar(&upstreamSNI, "upstream-sni", "", "TLS server name (SNI) to use for an https:// local service")
+	connectCmd.Flags().StringVar(&hostHeader, "host-header", models.HostHeaderRewrite, "Host header sent to the local service: preserve, rewrite (to the upstream host:port) or a literal value")
+	connectCmd.Flags().BoolVar(&noForwarded, "no-forwarded-headers", false, "Ask the server not to add X-Forwarded-* and Forwarded headers to requests")
+	connectCmd.Flags().StringVar(&inspectAddr, "inspect-addr", "127.0.0.1:4040", "Address of the local inspector web UI")
+	connectCmd.Flags().BoolVar(&noInspect, "no-inspect", false, "Disable the local inspector web UI")
+	connectCmd.Flags().StringVar(&harFile, "har", "", "Record the tunnel's traffic to a HAR file")
+	connectCmd.Flags().BoolVar(&serverHAR, "server-har", false, "Ask the server to record the tunnel's traffic as HAR (needs gts start --har-dir)")
+	connectCmd.Flags().StringArrayVar(&basicAuth, "basic-auth", nil, "Protect the public URL with basic auth, as user:password (repeatable)")
+	connectCmd.Flags().StringSliceVar(&allowCIDR, "allow-cidr", nil, "Only accept public requests from this IP or CIDR (repeatable)")
+	connectCmd.Flags().StringSliceVar(&denyCIDR, "deny-cidr", nil, "Reject public requests from this IP or CIDR (repeatable)")
+	connectCmd.Flags().StringVar(&rateLimit, "rate-limit", "", "Ask the server to limit public requests to this rate, e.g. 100/s or 600/m")
+	connectCmd.Flags().IntVar(&rateBurst, "rate-burst", 0, "Requests allowed at once with --rate-limit (defaults to its count)")
+	connectCmd.Flags().BoolVar(&oidcLogin, "oidc", false, "Ask public visitors to sign in with the server's OIDC provider")
+	connectCmd.Flags().StringSliceVar(&oidcEmailDomains, "oidc-email-domain", nil, "Only accept visitors signed in with an email in this domain, implies --oidc (repeatable)")
+	connectCmd.Flags().StringSliceVar(&oidcGroups, "oidc-group", nil, "Only accept visitors signed in as a member of this group, implies --oidc (repeatable)")
+	connectCmd.Flags().StringVar(&corsMode, "cors", "", "How the server handles cross-origin requests: passthrough (to the app), off or policy (defaults to the server setting)")
+	connectCmd.Flags().StringSliceVar(&corsOrigins, "cors-origin", nil, "Origin allowed by the server's CORS policy, implies --cors policy (repeatable)")
+	connectCmd.Flags().BoolVar(&corsCredentials, "cors-credentials", false, "Allow credentialed cross-origin requests with --cors-origin")
+	connectCmd.Flags().StringArrayVar(&requestHeaders, "request-header", nil, "Rewrite a request header before it reaches the local service: add:Name=value, set:Name=value or remove:Name (repeatable)")
+	connectCmd.Flags().StringArrayVar(&responseHeaders, "response-header", nil, "Rewrite a response header before it is returned: add:Name=value, set:Name=value or remove:Name (repeatable)")
+	connectCmd.Flags().StringVar(&pathMode, "path-mode", "", "Path sent to the local service: strip (the tunnel prefix, default), preserve or rewrite")
+	connectCmd.Flags().StringArrayVar(&pathRewrites, "path-rewrite", nil, "Rewrite the path without the prefix as regexp=replacement, e.g. '^/api/(.*)=/v2/$1', implies --path-mode rewrite (repeatable)")
+	connectCmd.Flags().BoolVar(&rewriteLocation, "rewrite-location", false, "Ask the server to add the tunnel prefix to redirects of the local service")
+	connectCmd.Flags().BoolVar(&rewriteCookiePath, "rewrite-cookie-path", false, "Ask the server to add the tunnel prefix to the Path of cookies set by the local service")
+	connectCmd.Flags().StringVar(&profileName, "profile", "", "Profile to use instead of the current one")
+	addLogFlags(connectCmd)
+}
+
+// loadClientConfig loads the client config with the --profile or current profile applied
+// and the access token resolved, or exits
+func loadClientConfig() *models.ClientConfig {
+	configRepo, config := loadProfileConfig()
+	if err := configRepo.ResolveAccessToken(config); err != nil {
+		logger.Fatalf("Failed to load access token: %v", err)
+	}
+	return config
+}
+
+// loadProfileConfig loads the client config with the --profile or current profile applied, or exits
+func loadProfileConfig() (repositories.ClientConfigRepository, *models.ClientConfig) {
+	configRepo := initConfigRepo()
+	config, err := configRepo.Load()
+	if err != nil {
+		logger.Fatalf("Failed to load config: %v", err)
+	}
+	if err := config.UseProfile(profileKey(profileName)); err != nil {
+		logger.Fatalf("Failed to load config: %v", err)
+	}
+	return configRepo, config
+}
+
+// setupLogging initializes the logger from config, flags take precedence
+func setupLogging(cmd *cobra.Command, config *models.ClientConfig) {
+	logConfig := resolveLogFlags(cmd, config.Log, debug)
+	if err := logger.ValidateLevel(logConfig.Level); err != nil {
+		logger.Fatalf("Invalid log configuration: %v", err)
+	}
+	if err := logger.Setup(logConfig, true); err != nil {
+		logger.Fatalf("Failed to set up logging: %v", err)
+	}
+}
+
+// resolveWebSocketURL returns the tunnel endpoint of the --server-url flag or the configured server
+func resolveWebSocketURL(config *models.ClientConfig) *url.URL {
+	if config.CurrentProfile != "" {
+		logger.Infof("Using profile %s", config.CurrentProfile)
+	}
+
+	finalServerURL := serverURL
+	if finalServerURL == "" {
+		finalServerURL = config.ServerURL
+		if finalServerURL == "" {
+			logger.Fatal("No server URL provided. Use --server-url flag or set it in config with 'gtc config --set-url <url>'")
+		}
+	}
+
+	// Build the complete WebSocket URL with endpoint
+	wsURL, err := buildWebSocketURL(finalServerURL)
+	if err != nil {
+		logger.Fatalf("Failed to build WebSocket URL: %v", err)
+	}
+
+	u, err := url.Parse("wss://" + wsURL)
+	if err != nil {
+		logger.Fatalf("Failed to parse WebSocket URL: %v", err)
+	}
+	return u
+}
+
+// clientOptions builds the settings shared by the tunnels of this process and starts the inspector
+func clientOptions(cmd *cobra.Command, config *models.ClientConfig) client.Options {
+	inspectorConfig := config.Inspector
+	if cmd.Flags().Changed("inspect-addr") {
+		inspectorConfig.Addr = inspectAddr
+	}
+	if noInspect {
+		inspectorConfig.Disabled = true
+	}
+
+	return client.Options{
+		AccessToken: config.AccessToken,
+		ServerTLS:   config.ServerTLS,
+		Timeouts:    config.Timeouts,
+		Inspector:   client.StartInspector(inspectorConfig),
+		HAR: har.Options{
+			MaxBodySize:   config.HAR.MaxBodySize,
+			RedactHeaders: config.HAR.RedactHeaders,
+		},
+	}
 }
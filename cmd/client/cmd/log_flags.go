@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+// logFlags hold the --log-* flags, shared by the commands that register them
+var logFlags struct {
+	level          string
+	format         string
+	file           string
+	maxSizeMB      int
+	rotateInterval time.Duration
+	maxBackups     int
+}
+
+// addLogFlags registers the --log-* flags on cmd
+func addLogFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&logFlags.level, "log-level", "", "Log level (trace|debug|info|warn|error)")
+	cmd.Flags().StringVar(&logFlags.format, "log-format", "", "Log output format (text|json)")
+	cmd.Flags().StringVar(&logFlags.file, "log-file", "", "Write logs to this file instead of stdout")
+	cmd.Flags().IntVar(&logFlags.maxSizeMB, "log-max-size", 0, "Rotate the log file when it reaches this size in MB")
+	cmd.Flags().DurationVar(&logFlags.rotateInterval, "log-rotate-interval", 0, "Rotate the log file after this interval (e.g. 24h)")
+	cmd.Flags().IntVar(&logFlags.maxBackups, "log-max-backups", 0, "Number of rotated log files to keep (0 keeps all)")
+}
+
+// resolveLogFlags overrides the configured log settings with the --log-* flags set explicitly on cmd,
+// debug forces the debug level
+func resolveLogFlags(cmd *cobra.Command, cfg logger.Config, debug bool) logger.Config {
+	flags := cmd.Flags()
+	if flags.Changed("log-level") {
+		cfg.Level = logger.LogLevel(logFlags.level)
+	}
+	if flags.Changed("log-format") {
+		cfg.Format = logger.LogFormat(logFlags.format)
+	}
+	if flags.Changed("log-file") {
+		cfg.File = logFlags.file
+	}
+	if flags.Changed("log-max-size") {
+		cfg.Rotation.MaxSizeMB = logFlags.maxSizeMB
+	}
+	if flags.Changed("log-rotate-interval") {
+		cfg.Rotation.Interval = logFlags.rotateInterval
+	}
+	if flags.Changed("log-max-backups") {
+		cfg.Rotation.MaxBackups = logFlags.maxBackups
+	}
+	if debug {
+		cfg.Level = logger.LevelDebug
+	}
+	if cfg.Level == "" {
+		cfg.Level = logger.LevelInfo
+	}
+	return cfg
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/repositories"
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var loginName string
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log this device in to the server",
+	Long: `Log in with a device code instead of pasting the shared access token. The server
+gives a code that an admin approves on the server's device page or with
+'gts approve <code>'. This device then gets its own access token, saved to the
+current profile or the client config, which can be revoked on its own.
+
+Examples:
+  gtc login                       # Log in to the configured server
+  gtc login --profile staging     # Log in to the server of the staging profile
+  gtc login --name ci-runner-3    # Name the device shown to the admin`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configRepo, config := loadProfileConfig()
+		serverAddr := config.ServerURL
+		if serverURL != "" {
+			serverAddr = serverURL
+		}
+		if serverAddr == "" {
+			logger.Fatal("No server URL provided. Use --server-url flag or set it in config with 'gtc config --set-url <url>'")
+		}
+
+		httpClient, err := newServerHTTPClient(config.ServerTLS, 30*time.Second)
+		if err != nil {
+			logger.Fatalf("Invalid server TLS settings: %v", err)
+		}
+
+		name := loginName
+		if name == "" {
+			if name, err = os.Hostname(); err != nil {
+				name = "unnamed device"
+			}
+		}
+
+		var code protocol.DeviceCodeResponse
+		if err := postDeviceJSON(httpClient, buildServerURL(serverAddr, protocol.DeviceCodePath), protocol.DeviceCodeRequest{Name: name}, &code); err != nil {
+			logger.Fatalf("Failed to start login: %v", err)
+		}
+
+		bold := color.New(color.Bold)
+		fmt.Print("To log in this device, approve the code ")
+		bold.Println(code.UserCode)
+		fmt.Printf("  - open %s?code=%s and enter the server access token, or\n", code.VerificationURI, url.QueryEscape(code.UserCode))
+		fmt.Printf("  - run 'gts approve %s' on the server\n", code.UserCode)
+		fmt.Println("Waiting for approval...")
+
+		token, err := pollDeviceToken(httpClient, buildServerURL(serverAddr, protocol.DeviceTokenPath), code)
+		if err != nil {
+			logger.Fatalf("Login failed: %v", err)
+		}
+
+		if config.CurrentProfile != "" {
+			profile := config.Profiles[config.CurrentProfile]
+			profile.AccessToken = token.AccessToken
+			if err := configRepo.SaveProfile(config.CurrentProfile, profile); err != nil {
+				logger.Fatalf("Failed to save access token: %v", err)
+			}
+		} else if err := configRepo.SetConfigValue("access_token", token.AccessToken); err != nil {
+			logger.Fatalf("Failed to save access token: %v", err)
+		}
+
+		color.New(color.FgGreen, color.Bold).Printf("Logged in as device %s\n", token.DeviceID)
+		if config.CurrentProfile != "" {
+			fmt.Printf("Access token saved to profile %s\n", config.CurrentProfile)
+		} else {
+			fmt.Println("Access token saved to the client config")
+		}
+
+		// the saved token is only used when no source with a higher precedence is set
+		switch {
+		case os.Getenv(repositories.AccessTokenEnv) != "":
+			fmt.Printf("Note: %s is set and takes precedence over the saved token\n", repositories.AccessTokenEnv)
+		case config.TokenFile != "":
+			fmt.Println("Note: token_file is set and takes precedence over the saved token")
+		case config.TokenCommand != "":
+			fmt.Println("Note: token_command is set and takes precedence over the saved token")
+		}
+	},
+}
+
+// pollDeviceToken waits for the login to be approved and returns the issued token
+func pollDeviceToken(httpClient *http.Client, tokenURL string, code protocol.DeviceCodeResponse) (*protocol.DeviceTokenResponse, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		var token protocol.DeviceTokenResponse
+		err := postDeviceJSON(httpClient, tokenURL, protocol.DeviceTokenRequest{DeviceCode: code.DeviceCode}, &token)
+		switch {
+		case token.Error == protocol.DeviceErrorPending:
+			continue
+		case token.Error == protocol.DeviceErrorSlowDown:
+			interval += 5 * time.Second // as RFC 8628 asks
+			continue
+		case token.Error == protocol.DeviceErrorExpired:
+			return nil, fmt.Errorf("the code expired before it was approved, run 'gtc login' again")
+		case err != nil:
+			return nil, err
+		}
+		return &token, nil
+	}
+	return nil, fmt.Errorf("the code expired before it was approved, run 'gtc login' again")
+}
+
+// postDeviceJSON posts a JSON request to a device flow endpoint and decodes the answer into out.
+// Errors reported by the server are decoded too, so callers can check their error field.
+func postDeviceJSON(httpClient *http.Client, endpoint string, in, out interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("the server does not support device login (404 from %s)", endpoint)
+	}
+	var apiErr protocol.DeviceTokenResponse
+	body := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		if err := body.Decode(&apiErr); err != nil {
+			return fmt.Errorf("server returned status %d", resp.StatusCode)
+		}
+		if token, ok := out.(*protocol.DeviceTokenResponse); ok {
+			*token = apiErr
+		}
+		if apiErr.ErrorDescription != "" {
+			return fmt.Errorf("%s: %s", apiErr.Error, apiErr.ErrorDescription)
+		}
+		return fmt.Errorf("%s", apiErr.Error)
+	}
+	if err := body.Decode(out); err != nil {
+		return fmt.Errorf("invalid response from the server: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	loginCmd.Flags().StringVarP(&serverURL, "server-url", "u", "", "Server URL to log in to instead of the configured one")
+	loginCmd.Flags().StringVar(&profileName, "profile", "", "Profile to log in with instead of the current one, the token is saved to it")
+	loginCmd.Flags().StringVar(&loginName, "name", "", "Device name shown to the admin (default: the hostname)")
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/client/repositories"
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+var (
+	profileServerURL  string
+	profileToken      string
+	profileTokenFile  string
+	profileTokenCmd   string
+	profileInsecure   bool
+	profileCA         string
+	profileServerName string
+	profileResponse   time.Duration
+	profileUse        bool
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage server profiles",
+	Long: `Manage named server profiles, each with its own server URL, access token, TLS settings
+and default timeouts.
+
+The current profile replaces the server settings of the config. Use --profile on connect,
+start and status to pick another profile for a single command.
+
+Examples:
+  gtc profile add staging --server-url wss://staging.example.com --token abc123
+  gtc profile use staging          # Make staging the current profile
+  gtc profile list                 # List profiles, the current one is marked with *
+  gtc connect 3000 --profile prod  # Connect with the prod profile once
+  gtc profile remove staging       # Delete the staging profile`,
+}
+
+var profileAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add or replace a profile",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := profileKey(args[0])
+		if profileServerURL == "" {
+			logger.Fatal("A profile needs a server URL, use --server-url")
+		}
+
+		configRepo := initConfigRepo()
+		profile := models.Profile{
+			ServerURL:    profileServerURL,
+			AccessToken:  profileToken,
+			TokenFile:    profileTokenFile,
+			TokenCommand: profileTokenCmd,
+			TLS: models.TLSConfig{
+				InsecureSkipVerify: profileInsecure,
+				CAFile:             profileCA,
+				ServerName:         profileServerName,
+			},
+			Timeouts: models.TimeoutConfig{Response: profileResponse},
+		}
+		if err := configRepo.SaveProfile(name, profile); err != nil {
+			logger.Fatalf("Failed to save profile: %v", err)
+		}
+		fmt.Printf("Profile %s saved\n", name)
+
+		if profileUse {
+			if err := configRepo.SetCurrentProfile(name); err != nil {
+				logger.Fatalf("Failed to switch profile: %v", err)
+			}
+			fmt.Printf("Now using profile %s\n", name)
+		}
+	},
+}
+
+var profileUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Make a profile the current one",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := profileKey(args[0])
+		if err := initConfigRepo().SetCurrentProfile(name); err != nil {
+			logger.Fatalf("Failed to switch profile: %v", err)
+		}
+		fmt.Printf("Now using profile %s\n", name)
+	},
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := initConfigRepo().Load()
+		if err != nil {
+			logger.Fatalf("Failed to load config: %v", err)
+		}
+		if len(config.Profiles) == 0 {
+			fmt.Println("No profiles, add one with 'gtc profile add <name> --server-url <url>'")
+			return
+		}
+
+		names := make([]string, 0, len(config.Profiles))
+		for name := range config.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "\tNAME\tSERVER URL\tTOKEN\tTLS")
+		for _, name := range names {
+			profile := config.Profiles[name]
+			marker := ""
+			if name == config.CurrentProfile {
+				marker = "*"
+			}
+			token := "-"
+			switch {
+			case profile.TokenFile != "":
+				token = "file"
+			case profile.TokenCommand != "":
+				token = "command"
+			case profile.AccessToken != "":
+				token = "set"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", marker, name, profile.ServerURL, token, describeTLS(profile.TLS))
+		}
+		w.Flush()
+	},
+}
+
+var profileRemoveCmd = &cobra.Command{
+	Use:     "remove <name>",
+	Aliases: []string{"rm"},
+	Short:   "Delete a profile",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := profileKey(args[0])
+		if err := initConfigRepo().RemoveProfile(name); err != nil {
+			logger.Fatalf("Failed to remove profile: %v", err)
+		}
+		fmt.Printf("Profile %s removed\n", name)
+	},
+}
+
+// initConfigRepo returns the initialized client config repository or exits
+func initConfigRepo() repositories.ClientConfigRepository {
+	configRepo := repositories.NewClientConfigRepo()
+	if err := configRepo.InitConfig(); err != nil {
+		logger.Fatalf("Failed to initialize config: %v", err)
+	}
+	return configRepo
+}
+
+// profileKey returns the name a profile is stored under, viper lowercases map keys
+func profileKey(name string) string {
+	return strings.ToLower(name)
+}
+
+func describeTLS(cfg models.TLSConfig) string {
+	var parts []string
+	if cfg.InsecureSkipVerify {
+		parts = append(parts, "insecure")
+	}
+	if cfg.CAFile != "" {
+		parts = append(parts, "ca="+cfg.CAFile)
+	}
+	if cfg.ServerName != "" {
+		parts = append(parts, "sni="+cfg.ServerName)
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ",")
+}
+
+func init() {
+	profileAddCmd.Flags().StringVarP(&profileServerURL, "server-url", "u", "", "Server URL of the profile")
+	profileAddCmd.Flags().StringVarP(&profileToken, "token", "t", "", "Access token for the server")
+	profileAddCmd.Flags().StringVar(&profileTokenFile, "token-file", "", "File to read the access token from")
+	profileAddCmd.Flags().StringVar(&profileTokenCmd, "token-command", "", "Credential helper command printing the access token")
+	profileAddCmd.Flags().BoolVar(&profileInsecure, "insecure", false, "Skip TLS certificate verification of the server")
+	profileAddCmd.Flags().StringVar(&profileCA, "ca-file", "", "PEM file with a CA to trust for the server")
+	profileAddCmd.Flags().StringVar(&profileServerName, "server-name", "", "TLS server name (SNI) to verify the server certificate against")
+	profileAddCmd.Flags().DurationVar(&profileResponse, "response-timeout", 0, "Default response timeout to request from the server")
+	profileAddCmd.Flags().BoolVar(&profileUse, "use", false, "Make the profile the current one")
+
+	profileCmd.AddCommand(profileAddCmd)
+	profileCmd.AddCommand(profileUseCmd)
+	profileCmd.AddCommand(profileListCmd)
+	profileCmd.AddCommand(profileRemoveCmd)
+}
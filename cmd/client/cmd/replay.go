@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/inspector"
+	"github.com/B-AJ-Amar/gTunnel/internal/client/repositories"
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replayInspectAddr string
+	replayMethod      string
+	replayHeaders     []string
+	replayData        string
+	replayDataFile    string
+	replayJSON        bool
+)
+
+// inspectedExchange is the part of the inspector's request detail shown by the replay command
+type inspectedExchange struct {
+	ID         string  `json:"id"`
+	DurationMs float64 `json:"duration_ms"`
+	ErrorCode  string  `json:"error_code"`
+	Error      string  `json:"error"`
+	ReplayOf   string  `json:"replay_of"`
+	Request    struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+	} `json:"request"`
+	Response *struct {
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+	} `json:"response"`
+	ResponseBody *inspector.BodyView `json:"response_body"`
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <request-id>",
+	Short: "Replay a captured request against the local service",
+	Long: `Resend a request captured by the inspector of a running 'gtc connect' to its local service,
+and show the new response next to the original one.
+
+The method, headers and body can be changed before sending. A header given with an
+empty value (-H "Name:") is removed from the request.
+
+Examples:
+  gtc replay 6f1c2a1e-...                                   # Replay a request as it was received
+  gtc replay 6f1c2a1e-... -H "X-Debug: 1"                   # Replay with an extra header
+  gtc replay 6f1c2a1e-... -X PUT -d '{"status":"paid"}'     # Replay with another method and body`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+
+		addr := replayInspectAddr
+		if !cmd.Flags().Changed("inspect-addr") {
+			if addr = configuredInspectAddr(); addr == "" {
+				addr = inspector.DefaultAddr
+			}
+		}
+
+		edits, err := buildReplayEdits()
+		if err != nil {
+			logger.Fatalf("Invalid replay options: %v", err)
+		}
+
+		base := "http://" + addr + "/api/requests/" + url.PathEscape(id)
+		client := &http.Client{Timeout: 10 * time.Minute}
+
+		payload, err := json.Marshal(edits)
+		if err != nil {
+			logger.Fatalf("Failed to encode replay request: %v", err)
+		}
+		resp, err := client.Post(base+"/replay", "application/json", bytes.NewReader(payload))
+		if err != nil {
+			logger.Fatalf("Could not reach the inspector at %s, is 'gtc connect' running? (%v)", addr, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			logger.Fatalf("Failed to read replay response: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			var apiErr struct {
+				Error string `json:"error"`
+			}
+			json.Unmarshal(body, &apiErr)
+			logger.Fatalf("Replay failed: %s", apiErr.Error)
+		}
+
+		if replayJSON {
+			os.Stdout.Write(body)
+			return
+		}
+
+		var replayed inspectedExchange
+		if err := json.Unmarshal(body, &replayed); err != nil {
+			logger.Fatalf("Invalid replay response: %v", err)
+		}
+
+		// the original is only used for the comparison, it may have left the ring buffer since
+		var original *inspectedExchange
+		if resp, err := client.Get(base); err == nil {
+			if resp.StatusCode == http.StatusOK {
+				original = &inspectedExchange{}
+				if json.NewDecoder(resp.Body).Decode(original) != nil {
+					original = nil
+				}
+			}
+			resp.Body.Close()
+		}
+
+		printReplay(original, &replayed)
+	},
+}
+
+// configuredInspectAddr returns the inspector address from the client config, if any
+func configuredInspectAddr() string {
+	configRepo := repositories.NewClientConfigRepo()
+	if err := configRepo.InitConfig(); err != nil {
+		return ""
+	}
+	config, err := configRepo.Load()
+	if err != nil {
+		return ""
+	}
+	return config.Inspector.Addr
+}
+
+func buildReplayEdits() (inspector.ReplayEdits, error) {
+	edits := inspector.ReplayEdits{Method: replayMethod}
+
+	for _, header := range replayHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return edits, fmt.Errorf("header %q must be in the form 'Name: value'", header)
+		}
+		if edits.Headers == nil {
+			edits.Headers = make(map[string]string)
+		}
+		edits.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	switch {
+	case replayData != "" && replayDataFile != "":
+		return edits, fmt.Errorf("--data and --data-file can't be used together")
+	case replayDataFile != "":
+		data, err := os.ReadFile(replayDataFile)
+		if err != nil {
+			return edits, err
+		}
+		body := string(data)
+		edits.Body = &body
+	case replayData != "":
+		edits.Body = &replayData
+	}
+	return edits, nil
+}
+
+func printReplay(original, replayed *inspectedExchange) {
+	cyan := color.New(color.FgCyan, color.Bold)
+	cyan.Printf("Replayed %s %s\n", replayed.Request.Method, replayed.Request.URL)
+	fmt.Printf("Replay ID: %s (replay of %s)\n\n", replayed.ID, replayed.ReplayOf)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	if original != nil {
+		fmt.Fprintln(w, "\tOriginal\tReplay")
+		fmt.Fprintf(w, "Status\t%s\t%s\n", exchangeStatus(original), exchangeStatus(replayed))
+		fmt.Fprintf(w, "Duration\t%.1f ms\t%.1f ms\n", original.DurationMs, replayed.DurationMs)
+		fmt.Fprintf(w, "Body\t%s\t%s\n", bodySize(original), bodySize(replayed))
+	} else {
+		fmt.Fprintf(w, "Status\t%s\n", exchangeStatus(replayed))
+		fmt.Fprintf(w, "Duration\t%.1f ms\n", replayed.DurationMs)
+		fmt.Fprintf(w, "Body\t%s\n", bodySize(replayed))
+	}
+	w.Flush()
+
+	if replayed.Response == nil {
+		return
+	}
+
+	fmt.Println()
+	names := make([]string, 0, len(replayed.Response.Headers))
+	for name := range replayed.Response.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s: %s\n", name, replayed.Response.Headers[name])
+	}
+
+	if view := replayed.ResponseBody; view != nil && view.Kind != inspector.BodyEmpty {
+		fmt.Println()
+		fmt.Println(strings.TrimRight(view.Text, "\n"))
+		if view.Truncated {
+			fmt.Printf("... (truncated, %d bytes total)\n", view.Size)
+		}
+	}
+}
+
+func exchangeStatus(e *inspectedExchange) string {
+	if e.ErrorCode != "" {
+		return e.ErrorCode
+	}
+	if e.Response == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d %s", e.Response.Status, http.StatusText(e.Response.Status))
+}
+
+func bodySize(e *inspectedExchange) string {
+	if e.ResponseBody == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d bytes", e.ResponseBody.Size)
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayInspectAddr, "inspect-addr", inspector.DefaultAddr, "Address of the inspector of the running client")
+	replayCmd.Flags().StringVarP(&replayMethod, "method", "X", "", "Replace the request method")
+	replayCmd.Flags().StringArrayVarP(&replayHeaders, "header", "H", nil, "Set a request header ('Name: value'), an empty value removes it (repeatable)")
+	replayCmd.Flags().StringVarP(&replayData, "data", "d", "", "Replace the request body")
+	replayCmd.Flags().StringVar(&replayDataFile, "data-file", "", "Replace the request body with the content of a file")
+	replayCmd.Flags().BoolVar(&replayJSON, "json", false, "Print the replayed request as JSON")
+}
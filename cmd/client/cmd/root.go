@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/B-AJ-Amar/gTunnel/internal/client/repositories"
 	version "github.com/B-AJ-Amar/gTunnel/internal/pkg"
 	"github.com/spf13/cobra"
 )
@@ -10,6 +11,12 @@ var rootCmd = &cobra.Command{
 	Short:   "gTunnel client CLI for connecting to tunnel servers",
 	Long:    `A command-line tool to connect to and manage gTunnel client connections.`,
 	Version: version.GetVersion(),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if projectFile != "" {
+			repositories.SetProjectFile(projectFile)
+		}
+		repositories.SetProjectEnvExpansion(expandEnv)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// If version flag is used, it will be handled automatically by cobra
 		// Otherwise, show help
@@ -21,11 +28,23 @@ func Execute() {
 	cobra.CheckErr(rootCmd.Execute())
 }
 
+var (
+	projectFile string
+	expandEnv   bool
+)
+
 func init() {
+	rootCmd.PersistentFlags().StringVar(&projectFile, "config", "", "Project file to merge over the user config (default: the closest .gtunnel.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&expandEnv, "expand-env", false, "Expand ${VAR} references in the .gtunnel.yaml found in the working tree")
+
 	// Add subcommands
 	rootCmd.AddCommand(connectCmd)
+	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(profileCmd)
+	rootCmd.AddCommand(loginCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(replayCmd)
 	rootCmd.AddCommand(completionCmd)
 	rootCmd.AddCommand(versionCmd)
 
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client"
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+var startCmd = &cobra.Command{
+	Use:   "start [names...]",
+	Short: "Start the tunnels defined in the config",
+	Long: `Start several named tunnels from a single process. Tunnels are defined under
+"tunnels" in the client config, each with its own upstream, base URL and options:
+
+  tunnels:
+    api:
+      upstream: 8080
+      base_url: api
+    web:
+      upstream: https://localhost:5173
+      host_header: preserve
+      tls:
+        insecure_skip_verify: true
+
+Without names, every defined tunnel is started.
+
+Examples:
+  gtc start              # Start every tunnel in the config
+  gtc start api web      # Start only the api and web tunnels`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.ShowBanner("client")
+
+		config := loadClientConfig()
+		setupLogging(cmd, config)
+
+		tunnels, err := selectTunnels(config.Tunnels, args)
+		if err != nil {
+			logger.Fatalf("Cannot start tunnels: %v", err)
+		}
+
+		u := resolveWebSocketURL(config)
+		for _, tunnel := range tunnels {
+			upstream, err := client.ParseUpstream(tunnel.Upstream)
+			if err != nil {
+				logger.Fatalf("Invalid upstream for tunnel %s: %v", tunnel.Name, err)
+			}
+			if err := tunnel.Validate(); err != nil {
+				logger.Fatalf("Cannot start tunnel %s: %v", tunnel.Name, err)
+			}
+			logger.WithField("tunnel", tunnel.Name).Infof("Tunneling %s ...", upstream.String())
+		}
+
+		client.StartTunnels(*u, tunnels, clientOptions(cmd, config))
+	},
+}
+
+// selectTunnels returns the named tunnels from the config, or all of them when no names are given
+func selectTunnels(defined map[string]models.TunnelConfig, names []string) ([]models.TunnelConfig, error) {
+	if len(defined) == 0 {
+		return nil, fmt.Errorf("no tunnels defined, add them under 'tunnels' in the client config")
+	}
+
+	available := make([]string, 0, len(defined))
+	for name := range defined {
+		available = append(available, name)
+	}
+	sort.Strings(available)
+	if len(names) == 0 {
+		names = available
+	}
+
+	tunnels := make([]models.TunnelConfig, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		// viper lowercases map keys
+		key := strings.ToLower(name)
+		tunnel, ok := defined[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown tunnel %q, defined tunnels: %s", name, strings.Join(available, ", "))
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		tunnel.Name = key
+		tunnels = append(tunnels, tunnel)
+	}
+	return tunnels, nil
+}
+
+func init() {
+	startCmd.Flags().StringVarP(&serverURL, "server-url", "u", "", "Server URL (without WebSocket endpoint, e.g., example.com:443)")
+	startCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging")
+	startCmd.Flags().StringVar(&profileName, "profile", "", "Profile to use instead of the current one")
+	startCmd.Flags().StringVar(&inspectAddr, "inspect-addr", "127.0.0.1:4040", "Address of the local inspector web UI")
+	startCmd.Flags().BoolVar(&noInspect, "no-inspect", false, "Disable the local inspector web UI")
+	addLogFlags(startCmd)
+}
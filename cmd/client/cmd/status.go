@@ -3,10 +3,11 @@ package cmd
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/B-AJ-Amar/gTunnel/internal/client/repositories"
-	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/client"
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
@@ -21,21 +22,12 @@ var statusCmd = &cobra.Command{
 	Long: `Display the current connection status to the gTunnel server.
 
 Examples:
-  gtc status           # Show basic connection status
-  gtc status -v        # Show detailed connection information`,
+  gtc status                    # Show basic connection status
+  gtc status -v                 # Show detailed connection information
+  gtc status --profile staging  # Check the server of the staging profile`,
 	Run: func(cmd *cobra.Command, args []string) {
-		configRepo := repositories.NewClientConfigRepo()
-
-		// Initialize config before loading
-		if err := configRepo.InitConfig(); err != nil {
-			logger.Fatalf("Failed to initialize config: %v", err)
-		}
-
 		// Load config to get server URL
-		config, err := configRepo.Load()
-		if err != nil {
-			logger.Fatalf("Failed to load config: %v", err)
-		}
+		config := loadClientConfig()
 
 		if config.ServerURL == "" {
 			printError("Not configured")
@@ -44,12 +36,15 @@ Examples:
 		}
 
 		if verbose {
+			if config.CurrentProfile != "" {
+				fmt.Printf("Profile: %s\n", config.CurrentProfile)
+			}
 			fmt.Printf("Server URL: %s\n", config.ServerURL)
 			fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 		}
 
 		// Check server health
-		status, isConnected := checkServerHealth(config.ServerURL)
+		status, isConnected := checkServerHealth(config.ServerURL, config.ServerTLS)
 		if isConnected {
 			printSuccess(status)
 		} else {
@@ -58,7 +53,7 @@ Examples:
 	},
 }
 
-func checkServerHealth(serverURL string) (string, bool) {
+func checkServerHealth(serverURL string, tlsCfg models.TLSConfig) (string, bool) {
 	// Build health check URL - convert ws/wss to http/https
 	healthURL := buildHealthURL(serverURL)
 	
@@ -67,13 +62,14 @@ func checkServerHealth(serverURL string) (string, bool) {
 	}
 	
 	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	httpClient, err := newServerHTTPClient(tlsCfg, 10*time.Second)
+	if err != nil {
+		return fmt.Sprintf("Not connected (Invalid TLS settings: %v)", err), false
 	}
 
 	// Send GET request to health endpoint
 	start := time.Now()
-	resp, err := client.Get(healthURL)
+	resp, err := httpClient.Get(healthURL)
 	duration := time.Since(start)
 	
 	if err != nil {
@@ -100,8 +96,25 @@ func checkServerHealth(serverURL string) (string, bool) {
 	return fmt.Sprintf("Not connected (Server returned status: %d)", resp.StatusCode), false
 }
 
+// newServerHTTPClient returns an HTTP client verifying the server with the configured TLS settings
+func newServerHTTPClient(tlsCfg models.TLSConfig, timeout time.Duration) (*http.Client, error) {
+	tlsConfig, err := client.NewTLSConfig(tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}, nil
+}
+
 func buildHealthURL(serverURL string) string {
-	// Convert WebSocket URL to HTTP URL for health check
+	return buildServerURL(serverURL, "/___gTl___/health")
+}
+
+// buildServerURL returns the HTTP URL of a server endpoint
+func buildServerURL(serverURL, path string) string {
+	// Convert WebSocket URL to HTTP URL
 	httpURL := serverURL
 	
 	// Add protocol if not present
@@ -116,13 +129,7 @@ func buildHealthURL(serverURL string) string {
 		httpURL = "https://" + httpURL[6:]
 	}
 	
-	// Add health endpoint
-	if httpURL[len(httpURL)-1] != '/' {
-		httpURL += "/"
-	}
-	httpURL += "___gTl___/health"
-	
-	return httpURL
+	return strings.TrimSuffix(httpURL, "/") + path
 }
 
 func hasProtocol(url string) bool {
@@ -144,4 +151,5 @@ func printError(message string) {
 
 func init() {
 	statusCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show detailed connection information")
+	statusCmd.Flags().StringVar(&profileName, "profile", "", "Profile to check instead of the current one")
 }
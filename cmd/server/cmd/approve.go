@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/repositories"
+	"github.com/spf13/cobra"
+)
+
+var approveCmd = &cobra.Command{
+	Use:   "approve <code>",
+	Short: "Approve a device logging in with gtc login",
+	Long: `Approve the code shown by 'gtc login' on a client. The client then receives its own
+access token, which can be revoked with 'gts devices revoke'.
+
+Examples:
+  gts approve BKLM-QRTW`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		auth, err := repositories.NewDeviceRepo().Approve(args[0])
+		if err != nil {
+			logger.Fatalf("Failed to approve %s: %v", args[0], err)
+		}
+		fmt.Printf("Approved %s for %q (from %s)\n", auth.UserCode, auth.Name, auth.RemoteAddr)
+	},
+}
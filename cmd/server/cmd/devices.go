@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/repositories"
+	"github.com/spf13/cobra"
+)
+
+var devicesAll bool
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List the devices logged in with gtc login",
+	Long: `List the devices that logged in with 'gtc login' and the logins waiting for approval.
+
+Examples:
+  gts devices                    # List active devices and pending logins
+  gts devices --all              # Include revoked devices
+  gts devices revoke 3f2a9c1e    # Revoke a device by ID or ID prefix`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := repositories.NewDeviceRepo().List()
+		if err != nil {
+			logger.Fatalf("Failed to list devices: %v", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCREATED\tLAST USED\tSTATUS")
+		shown := 0
+		for _, device := range store.Devices {
+			status := "active"
+			if device.Revoked() {
+				if !devicesAll {
+					continue
+				}
+				status = "revoked " + device.RevokedAt.Format(time.DateTime)
+			}
+			lastUsed := "-"
+			if device.LastUsedAt != nil {
+				lastUsed = device.LastUsedAt.Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", device.ID[:8], device.Name, device.CreatedAt.Format(time.DateTime), lastUsed, status)
+			shown++
+		}
+		w.Flush()
+		if shown == 0 {
+			fmt.Println("No devices, log one in with 'gtc login'")
+		}
+
+		if len(store.Pending) > 0 {
+			fmt.Println()
+			w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "CODE\tNAME\tFROM\tEXPIRES\tSTATUS")
+			for _, auth := range store.Pending {
+				status := "waiting for approval"
+				if auth.ApprovedAt != nil {
+					status = "approved, waiting for the client"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", auth.UserCode, auth.Name, auth.RemoteAddr, time.Until(auth.ExpiresAt).Round(time.Second), status)
+			}
+			w.Flush()
+		}
+	},
+}
+
+var devicesRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke the access token of a device",
+	Long: `Revoke the access token of a device by ID or by an ID prefix. New tunnels are refused
+right away and open tunnels of the device are closed by the running server within 30 seconds.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		device, err := repositories.NewDeviceRepo().Revoke(args[0])
+		if err != nil {
+			logger.Fatalf("Failed to revoke %s: %v", args[0], err)
+		}
+		fmt.Printf("Revoked device %s (%s)\n", device.ID, device.Name)
+	},
+}
+
+func init() {
+	devicesCmd.Flags().BoolVarP(&devicesAll, "all", "a", false, "Include revoked devices")
+	devicesCmd.AddCommand(devicesRevokeCmd)
+}
@@ -25,6 +25,9 @@ func init() {
 	// Add subcommands
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(approveCmd)
+	rootCmd.AddCommand(devicesCmd)
+	rootCmd.AddCommand(usageCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(completionCmd)
 	rootCmd.AddCommand(versionCmd)
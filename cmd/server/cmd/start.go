@@ -1,15 +1,25 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/B-AJ-Amar/gTunnel/internal/logger"
 	"github.com/B-AJ-Amar/gTunnel/internal/server"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/repositories"
 	"github.com/spf13/cobra"
 )
 
 var (
-	bindAddress string
-	debug       bool
+	bindAddress        string
+	debug              bool
+	authTimeout        time.Duration
+	responseTimeout    time.Duration
+	maxResponseTimeout time.Duration
+	errorPagesDir      string
+	trustedProxies     []string
+	harDir             string
+	harAll             bool
 )
 
 var startCmd = &cobra.Command{
@@ -18,15 +28,22 @@ var startCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.ShowBanner("server")
 
-		logLevel := logger.LevelInfo
-		if debug {
-			logLevel = logger.LevelDebug
-		}
-		logger.Init(logLevel, true)
-
 		configRepo := repositories.NewServerConfigRepo()
 
 		config, err := configRepo.Load()
+
+		logConfig := logger.Config{}
+		if config != nil {
+			logConfig = config.Log
+		}
+		logConfig = resolveLogFlags(cmd, logConfig, debug)
+		if err := logger.ValidateLevel(logConfig.Level); err != nil {
+			logger.Fatalf("Invalid log configuration: %v", err)
+		}
+		if err := logger.Setup(logConfig, true); err != nil {
+			logger.Fatalf("Failed to set up logging: %v", err)
+		}
+
 		if err != nil {
 			logger.Criticalf("Failed to load config, authentication is not supported: %v", err)
 		}
@@ -37,11 +54,46 @@ var startCmd = &cobra.Command{
 			logger.Critical("Access token is not set in the config. Please set it for secure access.")
 		}
 
-		server.StartServer(bindAddress)
+		if config == nil {
+			config = &models.ServerConfig{}
+		}
+		if cmd.Flags().Changed("auth-timeout") {
+			config.Timeouts.Auth = authTimeout
+		}
+		if cmd.Flags().Changed("response-timeout") {
+			config.Timeouts.Response = responseTimeout
+		}
+		if cmd.Flags().Changed("max-response-timeout") {
+			config.Timeouts.MaxResponse = maxResponseTimeout
+		}
+		config.Timeouts = config.Timeouts.WithDefaults()
+		if cmd.Flags().Changed("error-pages-dir") {
+			config.ErrorPages.Dir = errorPagesDir
+		}
+		if cmd.Flags().Changed("trusted-proxy") {
+			config.TrustedProxies = trustedProxies
+		}
+		if cmd.Flags().Changed("har-dir") {
+			config.HAR.Dir = harDir
+		}
+		if cmd.Flags().Changed("har-all") {
+			config.HAR.All = harAll
+		}
+		logger.Debugf("Timeouts: auth=%s response=%s max_response=%s", config.Timeouts.Auth, config.Timeouts.Response, config.Timeouts.MaxResponse)
+
+		server.StartServer(bindAddress, config)
 	},
 }
 
 func init() {
 	startCmd.Flags().StringVar(&bindAddress, "bind-address", "0.0.0.0:7205", "Address to bind the server to (e.g., 0.0.0.0:8080)")
 	startCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging")
+	startCmd.Flags().DurationVar(&authTimeout, "auth-timeout", models.DefaultAuthTimeout, "Time a new tunnel has to authenticate")
+	startCmd.Flags().DurationVar(&responseTimeout, "response-timeout", models.DefaultResponseTimeout, "Default time to wait for a tunnel to answer a request")
+	startCmd.Flags().DurationVar(&maxResponseTimeout, "max-response-timeout", models.DefaultMaxResponseTimeout, "Maximum response timeout a tunnel may request")
+	startCmd.Flags().StringVar(&errorPagesDir, "error-pages-dir", "", "Directory with custom error page templates (<code>.html, <status>.html, error.html, and .json variants)")
+	startCmd.Flags().StringSliceVar(&trustedProxies, "trusted-proxy", nil, "IP or CIDR of a proxy in front of the server whose X-Forwarded-* headers are trusted (repeatable)")
+	startCmd.Flags().StringVar(&harDir, "har-dir", "", "Directory to record tunnel traffic as HAR files, for tunnels asking for it (gtc connect --server-har)")
+	startCmd.Flags().BoolVar(&harAll, "har-all", false, "Record the traffic of every tunnel, requires --har-dir")
+	addLogFlags(startCmd)
 }
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/repositories"
+	"github.com/spf13/cobra"
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show the traffic of tokens and tunnels against their quotas",
+	Long: `Show the requests and bytes of every token and tunnel for the current UTC day and month,
+and how much of their quotas is used. Quotas are set in token_policies.
+
+The running server saves usage every 30 seconds and when it stops, so the numbers can be
+slightly behind.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		usageRepo := repositories.NewUsageRepo()
+		store, err := usageRepo.Load()
+		if err != nil {
+			logger.Fatalf("Failed to load usage: %v", err)
+		}
+		config, err := repositories.NewServerConfigRepo().Load()
+		if err != nil {
+			logger.Fatalf("Failed to load config: %v", err)
+		}
+		if len(store.Tokens) == 0 && len(store.Tunnels) == 0 {
+			fmt.Println("No traffic recorded yet")
+			return
+		}
+
+		names := map[string]string{models.PolicySharedToken: "shared access token"}
+		if devices, err := repositories.NewDeviceRepo().List(); err == nil {
+			for _, device := range devices.Devices {
+				names[device.ID] = device.Name
+			}
+		}
+
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "TOKEN\tNAME\tTODAY\tTHIS MONTH\tQUOTA")
+		for _, key := range sortedKeys(store.Tokens) {
+			record := store.Tokens[key].At(now)
+			name := names[key]
+			if name == "" {
+				name = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", shortKey(key), name, formatCounter(record.Daily), formatCounter(record.Monthly), formatQuota(config.TokenPolicy(deviceID(key)).Quota, record, now))
+		}
+		w.Flush()
+
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "TUNNEL\tTOKEN\tTODAY\tTHIS MONTH\tQUOTA\tLAST SEEN")
+		for _, key := range sortedKeys(store.Tunnels) {
+			record := store.Tunnels[key].At(now)
+			quota := config.TokenPolicy(deviceID(record.Token)).TunnelQuota
+			fmt.Fprintf(w, "/%s\t%s\t%s\t%s\t%s\t%s\n", key, shortKey(record.Token), formatCounter(record.Daily), formatCounter(record.Monthly), formatQuota(quota, record, now), record.LastSeen.Format(time.DateTime))
+		}
+		w.Flush()
+
+		if !store.UpdatedAt.IsZero() {
+			fmt.Printf("\nSaved by the server at %s, days and months are UTC\n", store.UpdatedAt.Format(time.DateTime))
+		}
+	},
+}
+
+// deviceID turns a usage key back into the device ID expected by TokenPolicy
+func deviceID(key string) string {
+	if key == models.PolicySharedToken {
+		return ""
+	}
+	return key
+}
+
+func shortKey(key string) string {
+	if len(key) > 8 && key != models.PolicySharedToken {
+		return key[:8]
+	}
+	if key == "" {
+		return "-"
+	}
+	return key
+}
+
+func sortedKeys(records map[string]*models.UsageRecord) []string {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatCounter(c models.UsageCounter) string {
+	return fmt.Sprintf("%d req, %s", c.Requests, formatBytes(c.Bytes))
+}
+
+func formatQuota(quota models.Quota, record models.UsageRecord, now time.Time) string {
+	items := quota.Items(record, now)
+	if len(items) == 0 {
+		return "-"
+	}
+	parts := make([]string, 0, len(items))
+	for _, item := range items {
+		parts = append(parts, fmt.Sprintf("%s %.0f%%", item.Name, item.Fraction()*100))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// ClassifyUpstreamError maps an error from the local service to the error code reported to the server
+func ClassifyUpstreamError(err error) protocol.ErrorCode {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return protocol.ErrorCodeDNSFailure
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &certErr) || errors.As(err, &recordErr) {
+		return protocol.ErrorCodeUpstreamTLS
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return protocol.ErrorCodeConnectionRefused
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return protocol.ErrorCodeUpstreamTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return protocol.ErrorCodeUpstreamTimeout
+	}
+
+	return protocol.ErrorCodeUpstreamError
+}
+
+// errorMessages are what the server is told about a failed request. The cause itself, which can
+// name local hosts, ports or paths, stays in the client log.
+var errorMessages = map[protocol.ErrorCode]string{
+	protocol.ErrorCodeConnectionRefused: "The local service refused the connection.",
+	protocol.ErrorCodeDNSFailure:        "The host of the local service could not be resolved.",
+	protocol.ErrorCodeUpstreamTimeout:   "The local service did not respond in time.",
+	protocol.ErrorCodeUpstreamTLS:       "The TLS connection to the local service failed.",
+	protocol.ErrorCodeUpstreamError:     "The local service failed to respond.",
+	protocol.ErrorCodeInvalidRequest:    "The request could not be forwarded to the local service.",
+	protocol.ErrorCodeResponseTooLarge:  "The response of the local service is over the size limit of the tunnel.",
+}
+
+// sendError reports a failed request to the server so the public caller gets an answer right away.
+// Only the code and a generic message are sent, the caller logs the cause.
+func sendError(tunnel *models.ClientTunnelConn, requestID string, code protocol.ErrorCode, cause error) {
+	log := tunnel.Log.WithField("request_id", requestID)
+
+	message, ok := errorMessages[code]
+	if !ok {
+		message = errorMessages[protocol.ErrorCodeUpstreamError]
+	}
+	log.WithField("code", code).Debugf("Reporting failed request: %v", cause)
+
+	errMsg, err := protocol.NewErrorMessage(requestID, code, message)
+	if err != nil {
+		log.Errorf("Failed to create error message: %v", err)
+		return
+	}
+
+	data, err := protocol.SerializeMessage(errMsg)
+	if err != nil {
+		log.Errorf("Failed to serialize error message: %v", err)
+		return
+	}
+
+	if err := tunnel.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Errorf("Failed to send error message: %v", err)
+		return
+	}
+	log.WithField("code", code).Debug("Error message sent")
+}
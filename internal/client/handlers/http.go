@@ -5,84 +5,187 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/B-AJ-Amar/gTunnel/internal/client/inspector"
 	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
-	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/har"
 	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
 	"github.com/gorilla/websocket"
 )
 
 func ClientHTTPRequestHandler(socketMessage protocol.SocketMessage, tunnel *models.ClientTunnelConn) error {
+	log := tunnel.Log.WithField("request_id", socketMessage.ID)
+
 	var httpRequest protocol.HTTPRequestMessage
 	err := protocol.DeserializeMessage(socketMessage.Payload, &httpRequest)
 	if err != nil {
-		logger.Errorf("Error deserializing HTTP request: %v", err)
+		log.Errorf("Error deserializing HTTP request: %v", err)
+		sendError(tunnel, socketMessage.ID, protocol.ErrorCodeInvalidRequest, err)
+		return err
+	}
+	log.Infof("HTTP Request: %s %s", httpRequest.Method, httpRequest.URL)
+
+	exchange := tunnel.Inspector.Begin(socketMessage.ID, tunnel.BaseURL)
+	defer exchange.Finish()
+	exchange.SetOriginal(httpRequest)
+
+	started := time.Now()
+	response, code, err := ForwardRequest(tunnel, httpRequest, exchange)
+	recordHAR(tunnel, socketMessage.ID, httpRequest, started, response, err)
+	if err != nil {
+		exchange.SetError(string(code), err)
+		sendError(tunnel, socketMessage.ID, code, err)
 		return err
 	}
-	logger.Infof("HTTP Request: %s %s", httpRequest.Method, httpRequest.URL)
 
+	responsePayload, err := protocol.SerializeMessage(response)
+	if err != nil {
+		return err
+	}
+
+	responseMsg := protocol.SocketMessage{
+		ID:      socketMessage.ID, // reply to the same ID
+		Type:    protocol.MessageTypeHTTPResponse,
+		Payload: responsePayload,
+	}
+
+	// Serialize the response message
+	responseBytes, err := protocol.SerializeMessage(responseMsg)
+
+	if err != nil {
+		return err
+	}
+
+	if tunnel.MaxMessageSize > 0 && int64(len(responseBytes)) > tunnel.MaxMessageSize {
+		err := fmt.Errorf("response message is larger than the %d bytes allowed by the server", tunnel.MaxMessageSize)
+		sendError(tunnel, socketMessage.ID, protocol.ErrorCodeResponseTooLarge, err)
+		return err
+	}
+	if err := tunnel.WriteMessage(websocket.TextMessage, responseBytes); err != nil {
+		return err
+	}
+	log.WithField("status", response.StatusCode).Debug("HTTP response sent")
+
+	return nil
+}
+
+// ForwardRequest sends a tunneled request to the local service and returns its response.
+// On failure it also returns the error code to report to the server.
+// The exchange, if not nil, captures what was sent and received for the inspector.
+func ForwardRequest(tunnel *models.ClientTunnelConn, httpRequest protocol.HTTPRequestMessage, exchange *inspector.Exchange) (*protocol.HTTPResponseMessage, protocol.ErrorCode, error) {
 	// request
 	req, err := http.NewRequest(
 		httpRequest.Method,
-		fmt.Sprintf("http://%s:%s%s", tunnel.Host, tunnel.Port, httpRequest.URL),
+		BuildUpstreamURL(tunnel.Upstream, httpRequest.URL),
 		bytes.NewReader(httpRequest.Body),
 	)
 	if err != nil {
-		return err
+		return nil, protocol.ErrorCodeInvalidRequest, err
 	}
 
 	// Set headers
-	for key, value := range httpRequest.Headers {
-		req.Header.Set(key, value)
+	for key, values := range httpRequest.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
 	}
+	applyHostHeader(req, tunnel.Config.HostHeader, httpRequest.Host, tunnel.Upstream)
+	exchange.SetRequest(req, httpRequest.Body)
 
 	// Send request
-	client := &http.Client{}
+	client := tunnel.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, ClassifyUpstreamError(err), err
 	}
 	defer resp.Body.Close()
 
 	// Construct HTTPResponseMessage
 
-	respBody, err := io.ReadAll(resp.Body)
+	var body io.Reader = resp.Body
+	if tunnel.MaxResponseBody > 0 {
+		body = io.LimitReader(resp.Body, tunnel.MaxResponseBody+1)
+	}
+	respBody, err := io.ReadAll(body)
 	if err != nil {
-		return err
+		return nil, ClassifyUpstreamError(err), err
 	}
-
-	respHeaders := make(map[string]string)
-	for k, v := range resp.Header {
-		if len(v) > 0 {
-			respHeaders[k] = v[0]
-		}
+	if tunnel.MaxResponseBody > 0 && int64(len(respBody)) > tunnel.MaxResponseBody {
+		return nil, protocol.ErrorCodeResponseTooLarge, fmt.Errorf("response body is larger than the %d bytes allowed by the server", tunnel.MaxResponseBody)
 	}
+	exchange.SetResponse(resp.StatusCode, resp.Header, respBody)
 
-	response := protocol.HTTPResponseMessage{
+	return &protocol.HTTPResponseMessage{
 		StatusCode: resp.StatusCode,
-		Headers:    respHeaders,
+		Headers:    resp.Header.Clone(),
 		Body:       respBody,
+	}, "", nil
+}
+
+// recordHAR writes a tunneled request and the response returned for it to the tunnel's HAR file
+func recordHAR(tunnel *models.ClientTunnelConn, requestID string, req protocol.HTTPRequestMessage, started time.Time, resp *protocol.HTTPResponseMessage, err error) {
+	if tunnel.HAR == nil {
+		return
 	}
 
-	responsePayload, err := protocol.SerializeMessage(response)
+	record := har.Record{
+		StartedAt:      started,
+		Duration:       time.Since(started),
+		Method:         req.Method,
+		URL:            BuildUpstreamURL(tunnel.Upstream, req.URL),
+		RequestHeaders: req.Headers,
+		RequestBody:    req.Body,
+		Comment:        "request " + requestID,
+	}
 	if err != nil {
-		return err
+		record.Error = err.Error()
+	} else {
+		record.Status = resp.StatusCode
+		record.ResponseHeaders = resp.Headers
+		record.ResponseBody = resp.Body
 	}
 
-	responseMsg := protocol.SocketMessage{
-		// ID:      socketMessage.ID, // reply to the same ID
-		Type:    protocol.MessageTypeHTTPResponse,
-		Payload: responsePayload,
+	if err := tunnel.HAR.Write(record); err != nil {
+		tunnel.Log.WithField("request_id", requestID).Errorf("Failed to write HAR entry: %v", err)
 	}
+}
 
-	// Serialize the response message
-	responseBytes, err := protocol.SerializeMessage(responseMsg)
+// BuildUpstreamURL joins the local service URL (including its path prefix) with the
+// path and query of a tunneled request
+func BuildUpstreamURL(upstream *url.URL, requestURL string) string {
+	path, query, _ := strings.Cut(requestURL, "?")
+	if path == "" {
+		path = "/"
+	}
 
-	if err != nil {
-		return err
+	target := upstream.Scheme + "://" + upstream.Host + upstream.Path + path
+	if query != "" {
+		target += "?" + query
 	}
+	return target
+}
 
-	tunnel.Conn.WriteMessage(websocket.TextMessage, responseBytes)
+// applyHostHeader sets the Host sent to the local service according to the tunnel's host header mode
+// and keeps the public host in X-Forwarded-Host
+func applyHostHeader(req *http.Request, mode, publicHost string, upstream *url.URL) {
+	switch mode {
+	case models.HostHeaderPreserve:
+		if publicHost != "" {
+			req.Host = publicHost
+		}
+	case models.HostHeaderRewrite, "":
+		req.Host = upstream.Host
+	default:
+		req.Host = mode
+	}
 
-	return nil
+	if publicHost != "" && req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", publicHost)
+	}
 }
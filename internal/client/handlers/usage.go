@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+// warnFraction of a quota is when the client starts warning
+const warnFraction = 0.8
+
+// HandleUsageReport warns about the quotas of the tunnel and its token that are close to or over their limit
+func HandleUsageReport(socketMessage protocol.SocketMessage, tunnel *models.ClientTunnelConn) error {
+	var report protocol.UsageReport
+	if err := protocol.DeserializeMessage(socketMessage.Payload, &report); err != nil {
+		return fmt.Errorf("invalid usage report: %w", err)
+	}
+
+	for _, quota := range report.Quotas {
+		if quota.Limit <= 0 {
+			continue
+		}
+		fraction := float64(quota.Used) / float64(quota.Limit)
+		if fraction < warnFraction {
+			tunnel.Log.Debugf("%s %s quota: %s used", quota.Scope, quota.Name, describeQuota(quota))
+			continue
+		}
+
+		resets := quota.ResetsAt.Local().Format(time.DateTime)
+		if fraction < 1 {
+			tunnel.Log.Warnf("The %s %s quota is %.0f%% used (%s), it resets at %s", quota.Scope, quota.Name, fraction*100, describeQuota(quota), resets)
+		} else if quota.Throttle > 0 {
+			tunnel.Log.Warnf("The %s %s quota is used up (%s), traffic is throttled to %s/s until %s", quota.Scope, quota.Name, describeQuota(quota), formatBytes(quota.Throttle), resets)
+		} else {
+			tunnel.Log.Errorf("The %s %s quota is used up (%s), public requests are rejected until %s", quota.Scope, quota.Name, describeQuota(quota), resets)
+		}
+	}
+	return nil
+}
+
+func describeQuota(quota protocol.QuotaStatus) string {
+	if strings.HasSuffix(quota.Name, "_bytes") {
+		return formatBytes(quota.Used) + " of " + formatBytes(quota.Limit)
+	}
+	return fmt.Sprintf("%d of %d requests", quota.Used, quota.Limit)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
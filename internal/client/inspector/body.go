@@ -0,0 +1,76 @@
+package inspector
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	BodyEmpty  = "empty"
+	BodyJSON   = "json"
+	BodyText   = "text"
+	BodyBinary = "binary"
+)
+
+// BodyView is a body prepared for display: pretty-printed JSON, text, or a hex dump for binary data
+type BodyView struct {
+	Kind      string `json:"kind"`
+	Text      string `json:"text"`
+	Size      int    `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+func renderBody(msg *Message) BodyView {
+	view := BodyView{
+		Size:      msg.BodySize,
+		Truncated: msg.BodyTruncated,
+	}
+
+	body := msg.Body
+	switch {
+	case len(body) == 0:
+		view.Kind = BodyEmpty
+	case isJSON(msg, body):
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, body, "", "  "); err == nil {
+			view.Kind = BodyJSON
+			view.Text = pretty.String()
+		} else {
+			view.Kind = BodyText
+			view.Text = string(body)
+		}
+	case isText(body):
+		view.Kind = BodyText
+		view.Text = string(body)
+	default:
+		view.Kind = BodyBinary
+		view.Text = hex.Dump(body)
+	}
+	return view
+}
+
+func isJSON(msg *Message, body []byte) bool {
+	for name, value := range msg.Headers {
+		if strings.EqualFold(name, "Content-Type") && strings.Contains(value, "json") {
+			return true
+		}
+	}
+	trimmed := bytes.TrimSpace(body)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed)
+}
+
+// isText reports whether body is valid UTF-8 without control characters other than whitespace
+func isText(body []byte) bool {
+	if !utf8.Valid(body) {
+		return false
+	}
+	for _, r := range string(body) {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package inspector
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/google/uuid"
+)
+
+var ErrNotFound = errors.New("request not found")
+
+// ReplayFunc sends req to the local service of the named tunnel, recording the result into exchange.
+// It only returns an error when the request could not be sent at all, e.g. the tunnel is gone;
+// upstream failures are recorded on the exchange.
+type ReplayFunc func(tunnel string, req protocol.HTTPRequestMessage, exchange *Exchange) error
+
+// ReplayEdits are optional changes applied to a captured request before replaying it
+type ReplayEdits struct {
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"` // set these headers, an empty value removes the header
+	Body    *string           `json:"body,omitempty"`
+}
+
+// Apply returns a copy of req with the edits applied
+func (edits ReplayEdits) Apply(req protocol.HTTPRequestMessage) protocol.HTTPRequestMessage {
+	if edits.Method != "" {
+		req.Method = strings.ToUpper(edits.Method)
+	}
+	if edits.URL != "" {
+		req.URL = edits.URL
+	}
+
+	headers := req.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	for name, value := range edits.Headers {
+		name = http.CanonicalHeaderKey(name)
+		for existing := range headers {
+			if strings.EqualFold(existing, name) {
+				delete(headers, existing)
+			}
+		}
+		if value != "" {
+			headers[name] = []string{value}
+		}
+	}
+	req.Headers = headers
+
+	if edits.Body != nil {
+		req.Body = []byte(*edits.Body)
+		req.Headers.Del("Content-Length")
+	}
+	return req
+}
+
+// Replay resends the captured exchange id through replay and stores the result as a new exchange
+func (s *Store) Replay(id string, edits ReplayEdits, replay ReplayFunc) (*Exchange, error) {
+	original, ok := s.Get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if original.Original.Method == "" {
+		return nil, errors.New("request body is over the inspector's max body size, it was not kept for replay")
+	}
+
+	req := edits.Apply(original.Original)
+	exchange := s.Begin(uuid.New().String(), original.Tunnel)
+	exchange.ReplayOf = original.ID
+	exchange.SetOriginal(req)
+
+	if err := replay(original.Tunnel, req, exchange); err != nil {
+		return nil, err
+	}
+	exchange.Finish()
+	return exchange, nil
+}
@@ -0,0 +1,246 @@
+package inspector
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+//go:embed ui/index.html
+var ui embed.FS
+
+// summary is the list view of an exchange
+type summary struct {
+	ID         string    `json:"id"`
+	Tunnel     string    `json:"tunnel"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs float64   `json:"duration_ms"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status,omitempty"`
+	ErrorCode  string    `json:"error_code,omitempty"`
+	ReplayOf   string    `json:"replay_of,omitempty"`
+}
+
+// detail is the full view of an exchange, with bodies prepared for display
+type detail struct {
+	*Exchange
+	DurationMs   float64   `json:"duration_ms"`
+	RequestBody  BodyView  `json:"request_body"`
+	ResponseBody *BodyView `json:"response_body,omitempty"`
+
+	// Original is the tunneled request in the form accepted by the replay endpoint, nil if it can't be replayed
+	Original *ReplayEdits `json:"original,omitempty"`
+}
+
+func newSummary(e *Exchange) summary {
+	s := summary{
+		ID:         e.ID,
+		Tunnel:     e.Tunnel,
+		StartedAt:  e.StartedAt,
+		DurationMs: float64(e.Duration.Microseconds()) / 1000,
+		Method:     e.Request.Method,
+		URL:        e.Request.URL,
+		ErrorCode:  e.ErrorCode,
+		ReplayOf:   e.ReplayOf,
+	}
+	if e.Response != nil {
+		s.Status = e.Response.Status
+	}
+	return s
+}
+
+func newDetail(e *Exchange) detail {
+	d := detail{
+		Exchange:    e,
+		DurationMs:  float64(e.Duration.Microseconds()) / 1000,
+		RequestBody: renderBody(&e.Request),
+	}
+	if e.Response != nil {
+		body := renderBody(e.Response)
+		d.ResponseBody = &body
+	}
+	if e.Original.Method != "" {
+		body := string(e.Original.Body)
+		d.Original = &ReplayEdits{
+			Method:  e.Original.Method,
+			URL:     e.Original.URL,
+			Headers: flattenHeaders(e.Original.Headers),
+			Body:    &body,
+		}
+	}
+	return d
+}
+
+// Server serves the inspector web UI and its JSON API
+type Server struct {
+	store      *Store
+	replay     ReplayFunc
+	listenHost string
+	httpServer *http.Server
+}
+
+// NewServer creates the inspector server, replay may be nil to disable replaying requests
+func NewServer(addr string, store *Store, replay ReplayFunc) *Server {
+	s := &Server{store: store, replay: replay}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		s.listenHost = host
+	}
+
+	r := chi.NewRouter()
+	r.Use(s.sameOrigin)
+	r.Get("/", s.handleIndex)
+	r.Get("/api/requests", s.handleList)
+	r.Delete("/api/requests", s.handleClear)
+	r.Get("/api/requests/{id}", s.handleGet)
+	r.Post("/api/requests/{id}/replay", s.handleReplay)
+
+	s.httpServer = &http.Server{
+		Addr:    addr,
+		Handler: r,
+	}
+	return s
+}
+
+// Start listens on the configured address and serves in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Inspector UI available at http://%s", listener.Addr().String())
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Inspector server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Close() error {
+	return s.httpServer.Close()
+}
+
+// sameOrigin keeps other sites out of the inspector. Requests must be addressed to a loopback
+// host or the listen address, which web pages cannot get through DNS rebinding, and requests
+// changing something must come from the inspector's own page, or not from a browser at all, as JSON.
+func (s *Server) sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "the inspector only answers on localhost"})
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "cross-origin requests are not allowed"})
+				return
+			}
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); r.Method == http.MethodPost && mediaType != "application/json" {
+				writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "the request body must be application/json"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) allowedHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") || (s.listenHost != "" && strings.EqualFold(host, s.listenHost)) {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	page, err := ui.ReadFile("ui/index.html")
+	if err != nil {
+		http.Error(w, "inspector UI not available", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := Filter{
+		Method: query.Get("method"),
+		Status: query.Get("status"),
+		Query:  query.Get("q"),
+		Tunnel: query.Get("tunnel"),
+	}
+
+	exchanges := s.store.List(filter)
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 && limit < len(exchanges) {
+		exchanges = exchanges[:limit]
+	}
+
+	summaries := make([]summary, 0, len(exchanges))
+	for _, e := range exchanges {
+		summaries = append(summaries, newSummary(e))
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	e, ok := s.store.Get(chi.URLParam(r, "id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": ErrNotFound.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, newDetail(e))
+}
+
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if s.replay == nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "replay is not available"})
+		return
+	}
+
+	var edits ReplayEdits
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&edits); err != nil && !errors.Is(err, io.EOF) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid replay body: " + err.Error()})
+			return
+		}
+	}
+
+	e, err := s.store.Replay(chi.URLParam(r, "id"), edits, s.replay)
+	if errors.Is(err, ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, newDetail(e))
+}
+
+func (s *Server) handleClear(w http.ResponseWriter, r *http.Request) {
+	s.store.Clear()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
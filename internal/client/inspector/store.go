@@ -0,0 +1,239 @@
+package inspector
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+const (
+	DefaultAddr        = "127.0.0.1:4040"
+	DefaultBufferSize  = 200
+	DefaultMaxBodySize = 1 << 20 // 1 MiB captured per body
+)
+
+// Message is a captured request or response
+type Message struct {
+	Method        string            `json:"method,omitempty"`
+	URL           string            `json:"url,omitempty"`
+	Host          string            `json:"host,omitempty"`
+	Status        int               `json:"status,omitempty"`
+	Headers       map[string]string `json:"headers"`
+	Body          []byte            `json:"-"`
+	BodySize      int               `json:"body_size"`
+	BodyTruncated bool              `json:"body_truncated,omitempty"`
+}
+
+// Exchange is one request/response pair that went through the tunnel
+type Exchange struct {
+	ID        string        `json:"id"`
+	Tunnel    string        `json:"tunnel"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"-"`
+	Request   Message       `json:"request"`
+	Response  *Message      `json:"response,omitempty"`
+	ErrorCode string        `json:"error_code,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	ReplayOf  string        `json:"replay_of,omitempty"` // ID of the exchange this one replayed
+
+	// Original is the request as received through the tunnel, kept whole so it can be replayed.
+	// It is empty when the body is over the max body size.
+	Original protocol.HTTPRequestMessage `json:"-"`
+
+	store *Store
+}
+
+// Store keeps the most recent exchanges in a fixed-size ring buffer
+type Store struct {
+	mu          sync.RWMutex
+	entries     []*Exchange
+	next        int
+	maxBodySize int
+}
+
+func NewStore(size, maxBodySize int) *Store {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	return &Store{
+		entries:     make([]*Exchange, size),
+		maxBodySize: maxBodySize,
+	}
+}
+
+// Begin starts capturing an exchange, it is safe to call on a nil Store
+func (s *Store) Begin(id, tunnel string) *Exchange {
+	if s == nil {
+		return nil
+	}
+	return &Exchange{
+		ID:        id,
+		Tunnel:    tunnel,
+		StartedAt: time.Now(),
+		store:     s,
+	}
+}
+
+// SetOriginal keeps the tunneled request for replay, unless its body is over the max body size
+func (e *Exchange) SetOriginal(req protocol.HTTPRequestMessage) {
+	if e == nil || len(req.Body) > e.store.maxBodySize {
+		return
+	}
+	e.Original = req
+}
+
+// SetRequest records the request sent to the local service
+func (e *Exchange) SetRequest(req *http.Request, body []byte) {
+	if e == nil {
+		return
+	}
+	e.Request = e.store.message(req.Header, body)
+	e.Request.Method = req.Method
+	e.Request.URL = req.URL.String()
+	e.Request.Host = req.Host
+}
+
+// SetResponse records the response returned by the local service
+func (e *Exchange) SetResponse(status int, headers http.Header, body []byte) {
+	if e == nil {
+		return
+	}
+	msg := e.store.message(headers, body)
+	msg.Status = status
+	e.Response = &msg
+}
+
+// SetError records why the exchange failed
+func (e *Exchange) SetError(code string, err error) {
+	if e == nil || err == nil {
+		return
+	}
+	e.ErrorCode = code
+	e.Error = err.Error()
+}
+
+// Finish stores the exchange, it is safe to call on a nil Exchange
+func (e *Exchange) Finish() {
+	if e == nil {
+		return
+	}
+	e.Duration = time.Since(e.StartedAt)
+	e.store.add(e)
+}
+
+func (s *Store) message(headers http.Header, body []byte) Message {
+	msg := Message{
+		Headers:  flattenHeaders(headers),
+		BodySize: len(body),
+	}
+	if len(body) > s.maxBodySize {
+		body = body[:s.maxBodySize]
+		msg.BodyTruncated = true
+	}
+	msg.Body = append([]byte(nil), body...)
+	return msg
+}
+
+// flattenHeaders joins the values of each header into one line for display and editing
+func flattenHeaders(headers http.Header) map[string]string {
+	flat := make(map[string]string, len(headers))
+	for name, values := range headers {
+		separator := ", "
+		if name == "Cookie" {
+			separator = "; "
+		}
+		flat[name] = strings.Join(values, separator)
+	}
+	return flat
+}
+
+func (s *Store) add(e *Exchange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[s.next] = e
+	s.next = (s.next + 1) % len(s.entries)
+}
+
+// Get returns the exchange with the given ID
+func (s *Store) Get(id string) (*Exchange, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.entries {
+		if e != nil && e.ID == id {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// Filter selects exchanges, empty fields match everything
+type Filter struct {
+	Method string // exact method, case insensitive
+	Status string // exact code ("404"), class ("4xx") or "error" for failed exchanges
+	Query  string // substring of the URL
+	Tunnel string
+}
+
+func (f Filter) match(e *Exchange) bool {
+	if f.Method != "" && !strings.EqualFold(f.Method, e.Request.Method) {
+		return false
+	}
+	if f.Tunnel != "" && f.Tunnel != e.Tunnel {
+		return false
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(e.Request.URL), strings.ToLower(f.Query)) {
+		return false
+	}
+	if f.Status != "" {
+		status := 0
+		if e.Response != nil {
+			status = e.Response.Status
+		}
+		switch {
+		case f.Status == "error":
+			return e.Error != ""
+		case len(f.Status) == 3 && strings.HasSuffix(strings.ToLower(f.Status), "xx"):
+			return status/100 == int(f.Status[0]-'0')
+		default:
+			return strconv.Itoa(status) == f.Status
+		}
+	}
+	return true
+}
+
+// List returns the matching exchanges, newest first
+func (s *Store) List(filter Filter) []*Exchange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*Exchange
+	size := len(s.entries)
+	for i := 1; i <= size; i++ {
+		e := s.entries[(s.next-i+size)%size]
+		if e == nil {
+			break
+		}
+		if filter.match(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Clear drops all captured exchanges
+func (s *Store) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = make([]*Exchange, len(s.entries))
+	s.next = 0
+}
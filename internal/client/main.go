@@ -2,16 +2,21 @@ package client
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/B-AJ-Amar/gTunnel/internal/client/handlers"
+	"github.com/B-AJ-Amar/gTunnel/internal/client/inspector"
 	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
-	"github.com/B-AJ-Amar/gTunnel/internal/client/repositories"
+	"github.com/B-AJ-Amar/gTunnel/internal/har"
 	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	version "github.com/B-AJ-Amar/gTunnel/internal/pkg"
 	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
 	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -19,9 +24,29 @@ var (
 	connMu      sync.Mutex
 )
 
+// Options holds the client-wide settings shared by the tunnels of a process
+type Options struct {
+	AccessToken string
+	Timeouts    models.TimeoutConfig
+	Inspector   *inspector.Store // nil disables request capture
+	HAR         har.Options      // applied to the tunnels recording HAR files
+	ServerTLS   models.TLSConfig // how the server certificate is verified
+}
+
+// newServerDialer returns the websocket dialer used to reach the server
+func newServerDialer(cfg models.TLSConfig) (*websocket.Dialer, error) {
+	tlsConfig, err := NewTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+	return &dialer, nil
+}
+
 // tryConnect attempts to connect with wss:// first, then falls back to ws://
 // If port is 0, it will try common ports (443 for wss, 80 for ws)
-func tryConnect(wsURL url.URL) (*websocket.Conn, error) {
+func tryConnect(wsURL url.URL, dialer *websocket.Dialer, log *logrus.Entry) (*websocket.Conn, error) {
 	hostname := wsURL.Hostname()
 	port := wsURL.Port()
 
@@ -32,26 +57,26 @@ func tryConnect(wsURL url.URL) (*websocket.Conn, error) {
 		secureURL.Scheme = "wss"
 		secureURL.Host = hostname + ":443"
 
-		logger.Infof("Attempting secure connection to %s...", secureURL.String())
-		conn, _, err := websocket.DefaultDialer.Dial(secureURL.String(), nil)
+		log.Infof("Attempting secure connection to %s...", secureURL.String())
+		conn, err := dial(dialer, secureURL)
 		if err == nil {
-			logger.Infof("Secure connection successful on port 443")
+			log.Infof("Secure connection successful on port 443")
 			return conn, nil
 		}
-		logger.Warnf("Secure connection failed on port 443: %v", err)
+		log.Warnf("Secure connection failed on port 443: %v", err)
 
 		// Try ws:// with port 80
 		insecureURL := wsURL
 		insecureURL.Scheme = "ws"
 		insecureURL.Host = hostname + ":80"
 
-		logger.Infof("Attempting insecure connection to %s...", insecureURL.String())
-		conn, _, err = websocket.DefaultDialer.Dial(insecureURL.String(), nil)
+		log.Infof("Attempting insecure connection to %s...", insecureURL.String())
+		conn, err = dial(dialer, insecureURL)
 		if err != nil {
 			return nil, fmt.Errorf("failed to connect on both port 443 (wss) and port 80 (ws). Last error: %w", err)
 		}
 
-		logger.Infof("Insecure connection successful on port 80")
+		log.Infof("Insecure connection successful on port 80")
 		return conn, nil
 	}
 
@@ -60,40 +85,105 @@ func tryConnect(wsURL url.URL) (*websocket.Conn, error) {
 	secureURL := wsURL
 	secureURL.Scheme = "wss"
 
-	logger.Infof("Attempting secure connection to %s...", secureURL.String())
-	conn, _, err := websocket.DefaultDialer.Dial(secureURL.String(), nil)
+	log.Infof("Attempting secure connection to %s...", secureURL.String())
+	conn, err := dial(dialer, secureURL)
 	if err == nil {
-		logger.Infof("Secure connection successful")
+		log.Infof("Secure connection successful")
 		return conn, nil
 	}
 
-	logger.Warnf("Secure connection failed: %v", err)
-	logger.Infof("Attempting insecure connection...")
+	log.Warnf("Secure connection failed: %v", err)
+	log.Infof("Attempting insecure connection...")
 
 	// Fallback to ws:// (insecure WebSocket)
 	insecureURL := wsURL
 	insecureURL.Scheme = "ws"
 
-	conn, _, err = websocket.DefaultDialer.Dial(insecureURL.String(), nil)
+	conn, err = dial(dialer, insecureURL)
 	if err != nil {
 		return nil, fmt.Errorf("both secure and insecure connections failed. Last error: %w", err)
 	}
 
-	logger.Infof("Insecure connection successful")
+	log.Infof("Insecure connection successful")
 	return conn, nil
 }
 
-func authenticate(wsURL url.URL, accessToken, baseURL string) (*models.ClientTunnelConn, error) {
+// dial opens the websocket, telling apart the server refusing the connection for too many attempts
+func dial(dialer *websocket.Dialer, u url.URL) (*websocket.Conn, error) {
+	conn, resp, err := dialer.Dial(u.String(), nil)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("too many connection attempts, retry in %ss", resp.Header.Get("Retry-After"))
+	}
+	return conn, err
+}
+
+// ParseHeaderRules parses rules given with --request-header or --response-header, written as
+// "add:Name=value", "set:Name=value" or "remove:Name"
+func ParseHeaderRules(entries []string) ([]models.HeaderRule, error) {
+	rules := make([]models.HeaderRule, 0, len(entries))
+	for _, entry := range entries {
+		action, header, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("header rule %q must be in the form add:Name=value, set:Name=value or remove:Name", entry)
+		}
+		name, value, _ := strings.Cut(header, "=")
+		rules = append(rules, models.HeaderRule{Action: action, Name: name, Value: value})
+	}
+	return rules, nil
+}
+
+// ParsePathRewrites parses rules given with --path-rewrite, written as "regexp=replacement"
+func ParsePathRewrites(entries []string) ([]models.PathRewrite, error) {
+	rewrites := make([]models.PathRewrite, 0, len(entries))
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("path rewrite %q must be in the form regexp=replacement, e.g. ^/api/(.*)=/v2/$1", entry)
+		}
+		rewrites = append(rewrites, models.PathRewrite{Match: entry[:i], Replace: entry[i+1:]})
+	}
+	return rewrites, nil
+}
+
+func authenticate(wsURL url.URL, dialer *websocket.Dialer, accessToken string, tunnelConfig models.TunnelConfig, upstream *url.URL, timeouts models.TimeoutConfig) (*models.ClientTunnelConn, error) {
+
+	log := logger.WithFields(logrus.Fields{})
+	if tunnelConfig.Name != "" {
+		log = log.WithField("tunnel", tunnelConfig.Name)
+	}
+
+	basicAuth, err := models.ParseBasicAuth(tunnelConfig.BasicAuth)
+	if err != nil {
+		return nil, err
+	}
 
-	conn, err := tryConnect(wsURL)
+	conn, err := tryConnect(wsURL, dialer, log)
 	if err != nil {
 		return nil, fmt.Errorf("connection failed: %w", err)
 	}
 
 	authRequest := protocol.AuthRequestMessage{
-		AccessToken: accessToken,
-		BaseURL:     baseURL,
+		ProtocolVersion:         protocol.Version,
+		AccessToken:             accessToken,
+		BaseURL:                 tunnelConfig.BaseURL,
+		ResponseTimeoutMs:       timeouts.Response.Milliseconds(),
+		DisableForwardedHeaders: tunnelConfig.DisableForwardedHeaders,
+		RecordHAR:               tunnelConfig.ServerHAR,
+		BasicAuth:               basicAuth,
+		AllowCIDRs:              tunnelConfig.AllowCIDRs,
+		DenyCIDRs:               tunnelConfig.DenyCIDRs,
+	}
+	if tunnelConfig.RateLimit.Rate != "" {
+		authRequest.RateLimit = &protocol.RateLimit{Rate: tunnelConfig.RateLimit.Rate, Burst: tunnelConfig.RateLimit.Burst}
+	}
+	if tunnelConfig.OIDC.Required() {
+		authRequest.OIDC = &protocol.OIDCRequirement{EmailDomains: tunnelConfig.OIDC.EmailDomains, Groups: tunnelConfig.OIDC.Groups}
 	}
+	authRequest.CORS = tunnelConfig.CORS.Policy()
+	if rules := tunnelConfig.Headers.Rules(); !rules.Empty() {
+		authRequest.Headers = &rules
+	}
+	authRequest.Paths = tunnelConfig.Path.Options(upstream.Host)
 
 	authMessage, err := protocol.NewSocketMessage("", protocol.MessageTypeAuthRequest, authRequest)
 	if err != nil {
@@ -113,7 +203,7 @@ func authenticate(wsURL url.URL, accessToken, baseURL string) (*models.ClientTun
 		return nil, fmt.Errorf("failed to send auth request: %w", err)
 	}
 
-	logger.Info("Authentication request sent, waiting for response...")
+	log.Info("Authentication request sent, waiting for response...")
 
 	_, message, err := conn.ReadMessage()
 	if err != nil {
@@ -145,30 +235,81 @@ func authenticate(wsURL url.URL, accessToken, baseURL string) (*models.ClientTun
 		return nil, fmt.Errorf("authentication failed: %s", authResponse.Message)
 	}
 
+	if authResponse.ProtocolVersion < protocol.Version {
+		conn.Close()
+		return nil, fmt.Errorf("the server speaks protocol version %d, this gtc needs %d: upgrade gts", max(authResponse.ProtocolVersion, 1), protocol.Version)
+	}
+
 	if authResponse.ID == nil {
 		conn.Close()
 		return nil, fmt.Errorf("authentication succeeded but no ID provided")
 	}
 
 	tunnel := &models.ClientTunnelConn{
-		ID:   *authResponse.ID,
-		Conn: conn,
+		ID:              *authResponse.ID,
+		Conn:            conn,
+		BaseURL:         authResponse.BaseURL,
+		ResponseTimeout: time.Duration(authResponse.ResponseTimeoutMs) * time.Millisecond,
+		MaxResponseBody: authResponse.MaxResponseBody,
+		MaxMessageSize:  authResponse.MaxMessageSize,
+		Log: log.WithFields(logrus.Fields{
+			"tunnel_id":   *authResponse.ID,
+			"base_url":    authResponse.BaseURL,
+			"remote_addr": conn.RemoteAddr().String(),
+		}),
 	}
 
-	httpURL := fmt.Sprintf("http://%s%s", wsURL.Host, baseURL)
-	logger.Infof("Authentication successful. Connection ID: %s", *authResponse.ID)
-	logger.Infof("Tunnel URL: %s", httpURL)
+	if authResponse.MaxMessageSize > 0 {
+		conn.SetReadLimit(authResponse.MaxMessageSize)
+	}
+
+	httpURL := fmt.Sprintf("http://%s/%s", wsURL.Host, authResponse.BaseURL)
+	tunnel.Log.Info("Authentication successful")
+	tunnel.Log.Infof("Tunnel URL: %s", httpURL)
+	if tunnel.ResponseTimeout > 0 {
+		tunnel.Log.Infof("Response timeout: %s", tunnel.ResponseTimeout)
+	}
+	if len(basicAuth) > 0 {
+		tunnel.Log.Infof("Public URL protected with basic auth (%d user(s))", len(basicAuth))
+	}
+	if limit := authRequest.RateLimit; limit != nil {
+		tunnel.Log.Infof("Public requests limited to %s", limit.Rate)
+	}
+	if rule := authRequest.OIDC; rule != nil {
+		tunnel.Log.Infof("Public URL requires OIDC sign-in (email domains %v, groups %v)", rule.EmailDomains, rule.Groups)
+	}
+	if policy := authRequest.CORS; policy != nil {
+		switch policy.Mode {
+		case protocol.CORSModePolicy:
+			tunnel.Log.Infof("CORS handled by the server for origins %v", policy.AllowOrigins)
+		case protocol.CORSModeOff:
+			tunnel.Log.Info("Cross-origin requests refused by the server")
+		}
+	}
+	if rules := authRequest.Headers; rules != nil {
+		tunnel.Log.Infof("Header rules: %d for requests, %d for responses", len(rules.Request), len(rules.Response))
+	}
+	if options := authRequest.Paths; options != nil && options.Mode != "" {
+		tunnel.Log.Infof("Path mode: %s", options.Mode)
+	}
+	if authResponse.RecordingHAR {
+		tunnel.Log.Info("The server is recording this tunnel's traffic as HAR")
+	} else if tunnelConfig.ServerHAR {
+		tunnel.Log.Warn("The server did not enable HAR recording for this tunnel")
+	}
 	return tunnel, nil
 }
 
-func WsClientHandler(tunnel *models.ClientTunnelConn, tunnelHost, tunnelPort string) {
+func WsClientHandler(tunnel *models.ClientTunnelConn, pingInterval time.Duration) {
 	conn := tunnel.Conn
 	id := tunnel.ID
+	log := tunnel.Log
 
-	tunnel.Host = tunnelHost
-	tunnel.Port = tunnelPort
+	if pingInterval <= 0 {
+		pingInterval = models.DefaultPingInterval
+	}
 
-	logger.Infof("Starting WebSocket handler for connection: %s", id)
+	log.Info("Starting WebSocket handler")
 
 	connMu.Lock()
 	connections[id] = tunnel
@@ -179,24 +320,24 @@ func WsClientHandler(tunnel *models.ClientTunnelConn, tunnelHost, tunnelPort str
 		delete(connections, id)
 		connMu.Unlock()
 		conn.Close()
-		logger.Infof("Connection closed: %s", id)
+		log.Info("Connection closed")
 	}()
 
 	conn.SetPongHandler(func(appData string) error {
-		logger.Debugf("Received pong: %s", appData)
+		log.Debugf("Received pong: %s", appData)
 		return nil
 	})
 
 	// Ping loop
 	go func() {
-		ticker := time.NewTicker(30 * time.Second)
+		ticker := time.NewTicker(pingInterval)
 		defer ticker.Stop()
 		for {
 			<-ticker.C
-			logger.Debugf("Sending ping to %s", id)
-			err := conn.WriteMessage(websocket.PingMessage, []byte("ping"))
+			log.Debug("Sending ping")
+			err := tunnel.WriteMessage(websocket.PingMessage, []byte("ping"))
 			if err != nil {
-				logger.Errorf("Ping failed, closing connection: %v", err)
+				log.Errorf("Ping failed, closing connection: %v", err)
 				conn.Close()
 				return
 			}
@@ -207,57 +348,153 @@ func WsClientHandler(tunnel *models.ClientTunnelConn, tunnelHost, tunnelPort str
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
-			logger.Errorf("Read error: %v", err)
+			log.Errorf("Read error: %v", err)
 			break
 		}
 
-		logger.Debugf("[%s] Received: %s", id, message)
+		log.Debugf("Received: %s", message)
 
 		var socketMessage protocol.SocketMessage
 		err = protocol.DeserializeMessage(message, &socketMessage)
 		if err != nil {
-			logger.Errorf("[%s] Error deserializing message: %v", id, err)
+			log.Errorf("Error deserializing message: %v", err)
 			continue
 		}
-		logger.Debugf("[%s] Message type: %d", id, socketMessage.Type)
+		msgLog := log.WithField("request_id", socketMessage.ID)
+		msgLog.Debugf("Message type: %d", socketMessage.Type)
 
 		switch socketMessage.Type {
 
 		case protocol.MessageTypeHTTPRequest:
-			err := handlers.ClientHTTPRequestHandler(socketMessage, tunnel)
-			if err != nil {
-				logger.Errorf("[%s] Error handling HTTP request: %v", id, err)
-				continue
+			// handle requests concurrently so a slow endpoint doesn't hold up the others
+			go func() {
+				err := handlers.ClientHTTPRequestHandler(socketMessage, tunnel)
+				if err != nil {
+					msgLog.Errorf("Error handling HTTP request: %v", err)
+					return
+				}
+				msgLog.Debug("HTTP response sent successfully")
+			}()
+
+		case protocol.MessageTypeUsage:
+			if err := handlers.HandleUsageReport(socketMessage, tunnel); err != nil {
+				log.Errorf("Error handling usage report: %v", err)
 			}
-			logger.Debugf("[%s] HTTP response sent successfully", id)
 
 		default:
-			logger.Warnf("[%s] Unknown message type: %d", id, socketMessage.Type)
+			msgLog.Warnf("Unknown message type: %d", socketMessage.Type)
 			continue
 		}
 	}
 }
 
-func StartClient(wsURL url.URL, tunnelHost, tunnelPort string, baseURL string) {
-	configRepo := repositories.NewClientConfigRepo()
-	if err := configRepo.InitConfig(); err != nil {
-		logger.Warnf("Failed to initialize config: %v", err)
+// RunTunnel authenticates a tunnel and serves it until its connection closes
+func RunTunnel(wsURL url.URL, tunnelConfig models.TunnelConfig, opts Options) error {
+	timeouts := opts.Timeouts.Override(tunnelConfig.Timeouts)
+
+	upstream, err := ParseUpstream(tunnelConfig.Upstream)
+	if err != nil {
+		return fmt.Errorf("invalid upstream: %w", err)
 	}
 
-	config, err := configRepo.Load()
+	dialer, err := newServerDialer(opts.ServerTLS)
 	if err != nil {
-		logger.Warnf("Failed to load config: %v", err)
+		return fmt.Errorf("invalid server TLS settings: %w", err)
 	}
 
-	accessToken := ""
-	if config != nil {
-		accessToken = config.AccessToken
+	tunnel, err := authenticate(wsURL, dialer, opts.AccessToken, tunnelConfig, upstream, timeouts)
+	if err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
 	}
 
-	tunnel, err := authenticate(wsURL, accessToken, baseURL)
+	tunnel.Upstream = upstream
+	tunnel.Config = tunnelConfig
+	tunnel.Inspector = opts.Inspector
+	tunnel.Log = tunnel.Log.WithField("upstream", upstream.String())
+	tunnel.HTTPClient, err = newUpstreamClient(tunnel, tunnelConfig.TLS, timeouts)
 	if err != nil {
-		logger.Fatalf("Authentication failed: %v", err)
+		tunnel.Conn.Close()
+		return fmt.Errorf("failed to set up upstream client: %w", err)
+	}
+
+	if tunnelConfig.HAR != "" {
+		tunnel.HAR, err = har.Create(tunnelConfig.HAR, har.Creator{Name: "gTunnel client", Version: version.GetVersion()}, opts.HAR)
+		if err != nil {
+			tunnel.Conn.Close()
+			return fmt.Errorf("failed to start HAR recording: %w", err)
+		}
+		tunnel.Log.Infof("Recording traffic to %s", tunnelConfig.HAR)
+		defer tunnel.HAR.Close()
+	}
+
+	WsClientHandler(tunnel, timeouts.Ping)
+	return nil
+}
+
+func StartClient(wsURL url.URL, tunnelConfig models.TunnelConfig, opts Options) {
+	if err := RunTunnel(wsURL, tunnelConfig, opts); err != nil {
+		logger.Fatalf("Tunnel failed: %v", err)
+	}
+}
+
+// StartTunnels runs several tunnels side by side, each over its own connection,
+// and returns once all of them are closed
+func StartTunnels(wsURL url.URL, tunnels []models.TunnelConfig, opts Options) {
+	var wg sync.WaitGroup
+	for _, tunnelConfig := range tunnels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := RunTunnel(wsURL, tunnelConfig, opts); err != nil {
+				logger.WithField("tunnel", tunnelConfig.Name).Errorf("Tunnel failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// StartInspector starts the inspector web UI and returns the store tunnels should record into.
+// It returns nil when the inspector is disabled or its address can't be used.
+func StartInspector(cfg models.InspectorConfig) *inspector.Store {
+	if cfg.Disabled {
+		return nil
+	}
+
+	addr := cfg.Addr
+	if addr == "" {
+		addr = inspector.DefaultAddr
 	}
 
-	WsClientHandler(tunnel, tunnelHost, tunnelPort)
+	store := inspector.NewStore(cfg.BufferSize, cfg.MaxBodySize)
+	if err := inspector.NewServer(addr, store, replayRequest).Start(); err != nil {
+		logger.Warnf("Inspector disabled, could not listen on %s: %v", addr, err)
+		return nil
+	}
+	return store
+}
+
+// replayRequest resends a captured request to the local service of a connected tunnel
+func replayRequest(baseURL string, req protocol.HTTPRequestMessage, exchange *inspector.Exchange) error {
+	var tunnel *models.ClientTunnelConn
+	connMu.Lock()
+	for _, conn := range connections {
+		if conn.BaseURL == baseURL {
+			tunnel = conn
+			break
+		}
+	}
+	connMu.Unlock()
+
+	if tunnel == nil {
+		return fmt.Errorf("tunnel %q is not connected", baseURL)
+	}
+
+	log := tunnel.Log.WithFields(logrus.Fields{"request_id": exchange.ID, "replay_of": exchange.ReplayOf})
+	log.Infof("Replaying request: %s %s", req.Method, req.URL)
+
+	if _, code, err := handlers.ForwardRequest(tunnel, req, exchange); err != nil {
+		log.Warnf("Replayed request failed: %v", err)
+		exchange.SetError(string(code), err)
+	}
+	return nil
 }
@@ -1,6 +1,69 @@
 package models
 
+import (
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+)
+
+const DefaultPingInterval = 30 * time.Second
+
 type ClientConfig struct {
 	AccessToken string `mapstructure:"access_token"`
-	ServerURL   string `mapstructure:"server_url"`
+
+	// TokenFile and TokenCommand keep the access token out of the config file,
+	// see repositories.ResolveAccessToken for the lookup order
+	TokenFile       string        `mapstructure:"token_file"`
+	TokenCommand    string        `mapstructure:"token_command"`
+	TokenCommandTTL time.Duration `mapstructure:"token_command_ttl"` // how long the command output is reused, defaults to 5m
+	TokenCache      bool          `mapstructure:"token_cache"`       // keep the command output on disk between runs
+	TokenSource     string        `mapstructure:"-"`                 // where the access token was read from
+
+	ServerURL string          `mapstructure:"server_url"`
+	ServerTLS TLSConfig       `mapstructure:"server_tls"` // how the server certificate is verified for wss:// URLs
+	Log       logger.Config   `mapstructure:"log"`
+	Timeouts  TimeoutConfig   `mapstructure:"timeouts"`
+	Inspector InspectorConfig `mapstructure:"inspector"`
+	HAR       HARConfig       `mapstructure:"har"`
+
+	// Tunnels are the named tunnels started together by gtc start
+	Tunnels map[string]TunnelConfig `mapstructure:"tunnels"`
+
+	// Profiles are named servers to switch between with gtc profile use or --profile
+	Profiles       map[string]Profile `mapstructure:"profiles"`
+	CurrentProfile string             `mapstructure:"current_profile"`
+}
+
+// HARConfig controls what is written to HAR files recorded with --har
+type HARConfig struct {
+	MaxBodySize   int      `mapstructure:"max_body_size"`  // bytes written per body
+	RedactHeaders []string `mapstructure:"redact_headers"` // defaults to Authorization, Proxy-Authorization, Cookie and Set-Cookie
+}
+
+// InspectorConfig controls the local web UI showing the traffic going through the tunnels
+type InspectorConfig struct {
+	Disabled    bool   `mapstructure:"disabled"`
+	Addr        string `mapstructure:"addr"`          // defaults to 127.0.0.1:4040
+	BufferSize  int    `mapstructure:"buffer_size"`   // number of requests kept in memory
+	MaxBodySize int    `mapstructure:"max_body_size"` // bytes captured per request/response body
+}
+
+type TimeoutConfig struct {
+	Upstream time.Duration `mapstructure:"upstream"` // local service timeout, defaults to the tunnel response timeout
+	Ping     time.Duration `mapstructure:"ping"`     // keepalive ping interval
+	Response time.Duration `mapstructure:"response"` // response timeout requested from the server for this tunnel
+}
+
+// Override returns t with the timeouts set in o replacing its own
+func (t TimeoutConfig) Override(o TimeoutConfig) TimeoutConfig {
+	if o.Upstream > 0 {
+		t.Upstream = o.Upstream
+	}
+	if o.Ping > 0 {
+		t.Ping = o.Ping
+	}
+	if o.Response > 0 {
+		t.Response = o.Response
+	}
+	return t
 }
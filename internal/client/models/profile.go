@@ -0,0 +1,54 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Profile is a named server with its own credentials, TLS settings and default timeouts
+type Profile struct {
+	ServerURL   string        `mapstructure:"server_url"`
+	AccessToken string        `mapstructure:"access_token"`
+	TLS         TLSConfig     `mapstructure:"tls"`
+	Timeouts    TimeoutConfig `mapstructure:"timeouts"`
+
+	TokenFile       string        `mapstructure:"token_file"`
+	TokenCommand    string        `mapstructure:"token_command"`
+	TokenCommandTTL time.Duration `mapstructure:"token_command_ttl"`
+	TokenCache      bool          `mapstructure:"token_cache"`
+}
+
+// UseProfile replaces the server settings of the config with the ones of the named profile.
+// An empty name selects the current profile, if one is set.
+func (c *ClientConfig) UseProfile(name string) error {
+	if name == "" {
+		name = c.CurrentProfile
+		if name == "" {
+			return nil
+		}
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+
+	c.CurrentProfile = name
+	if profile.ServerURL != "" {
+		c.ServerURL = profile.ServerURL
+	}
+	// the credentials of a profile are taken as a whole so they never mix with the top-level ones,
+	// and a profile with its own server never gets them, they would be sent to that server
+	if profile.AccessToken != "" || profile.TokenFile != "" || profile.TokenCommand != "" || profile.ServerURL != "" {
+		c.AccessToken = profile.AccessToken
+		c.TokenFile = profile.TokenFile
+		c.TokenCommand = profile.TokenCommand
+		c.TokenCommandTTL = profile.TokenCommandTTL
+		c.TokenCache = profile.TokenCache
+	}
+	if profile.TLS != (TLSConfig{}) {
+		c.ServerTLS = profile.TLS
+	}
+	c.Timeouts = c.Timeouts.Override(profile.Timeouts)
+	return nil
+}
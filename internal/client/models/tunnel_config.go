@@ -0,0 +1,241 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/headers"
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+const (
+	HostHeaderPreserve = "preserve" // send the public Host header
+	HostHeaderRewrite  = "rewrite"  // send the upstream host:port (default)
+)
+
+// TunnelConfig describes a single tunnel: the local service requests are forwarded to and how
+type TunnelConfig struct {
+	Name     string    `mapstructure:"-"`        // key of the tunnel in the config, empty for gtc connect
+	Upstream string    `mapstructure:"upstream"` // port, host:port or full URL (http:// or https://, optionally with a path prefix)
+	BaseURL  string    `mapstructure:"base_url"`
+	TLS      TLSConfig `mapstructure:"tls"`
+
+	// HostHeader is "preserve", "rewrite" or a literal Host value to send to the local service
+	HostHeader string `mapstructure:"host_header"`
+
+	// DisableForwardedHeaders asks the server not to add X-Forwarded-* and Forwarded headers
+	DisableForwardedHeaders bool `mapstructure:"disable_forwarded_headers"`
+
+	// BasicAuth lists "user:password" pairs the server asks public visitors for
+	BasicAuth []string `mapstructure:"basic_auth"`
+
+	// AllowCIDRs and DenyCIDRs restrict the networks that can reach the public URL (IPs or CIDRs)
+	AllowCIDRs []string `mapstructure:"allow_cidrs"`
+	DenyCIDRs  []string `mapstructure:"deny_cidrs"`
+
+	// RateLimit asks the server to limit the public requests of the tunnel
+	RateLimit RateLimit `mapstructure:"rate_limit"`
+
+	// OIDC asks the server to sign in public visitors with its OpenID Connect provider
+	OIDC OIDCAccess `mapstructure:"oidc"`
+
+	// CORS chooses how the server handles cross-origin requests, the server default when unset
+	CORS CORSConfig `mapstructure:"cors"`
+
+	// Headers asks the server to rewrite the headers of requests and responses
+	Headers HeaderRules `mapstructure:"headers"`
+
+	// Path controls the path the local service sees and the fixing up of its redirects and cookies
+	Path PathConfig `mapstructure:"path"`
+
+	// Timeouts override the client-wide timeouts for this tunnel
+	Timeouts TimeoutConfig `mapstructure:"timeouts"`
+
+	HAR       string `mapstructure:"har"`        // file to record the tunnel's traffic to as HAR
+	ServerHAR bool   `mapstructure:"server_har"` // ask the server to record the tunnel's traffic as HAR
+}
+
+// Validate checks the options of the tunnel the way the server will. The upstream is checked
+// when it is parsed, by client.ParseUpstream.
+func (c TunnelConfig) Validate() error {
+	if _, err := ParseBasicAuth(c.BasicAuth); err != nil {
+		return fmt.Errorf("invalid basic_auth: %w", err)
+	}
+	if err := ValidateCIDRs(c.AllowCIDRs); err != nil {
+		return fmt.Errorf("invalid allow_cidrs: %w", err)
+	}
+	if err := ValidateCIDRs(c.DenyCIDRs); err != nil {
+		return fmt.Errorf("invalid deny_cidrs: %w", err)
+	}
+	if _, _, err := protocol.ParseRate(c.RateLimit.Rate); err != nil {
+		return fmt.Errorf("invalid rate_limit: %w", err)
+	}
+	if err := c.CORS.Validate(); err != nil {
+		return fmt.Errorf("invalid cors: %w", err)
+	}
+	if _, err := headers.Compile(c.Headers.Rules()); err != nil {
+		return fmt.Errorf("invalid headers: %w", err)
+	}
+	if err := c.Path.Validate(); err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+	return nil
+}
+
+// ParseBasicAuth parses "user:password" pairs given with --basic-auth or in a tunnel config
+func ParseBasicAuth(entries []string) ([]protocol.BasicAuthCredential, error) {
+	credentials := make([]protocol.BasicAuthCredential, 0, len(entries))
+	for _, entry := range entries {
+		username, password, ok := strings.Cut(entry, ":")
+		if !ok || username == "" || password == "" {
+			return nil, fmt.Errorf("basic auth %q must be in the form user:password", entry)
+		}
+		credentials = append(credentials, protocol.BasicAuthCredential{Username: username, Password: password})
+	}
+	return credentials, nil
+}
+
+// ValidateCIDRs checks a list of IPs and CIDRs given with --allow-cidr or --deny-cidr
+func ValidateCIDRs(entries []string) error {
+	for _, entry := range entries {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("%q is not an IP or CIDR", entry)
+		}
+	}
+	return nil
+}
+
+// RateLimit is a token-bucket limit enforced by the server
+type RateLimit struct {
+	Rate  string `mapstructure:"rate"`  // "100/s", "600/m", "10/30s"
+	Burst int    `mapstructure:"burst"` // requests allowed at once, defaults to the count of Rate
+}
+
+// OIDCAccess restricts the public URL to visitors signed in with the server's OIDC provider
+type OIDCAccess struct {
+	Enabled      bool     `mapstructure:"enabled"`       // implied when a list is set
+	EmailDomains []string `mapstructure:"email_domains"` // the verified email must be in one of these domains
+	Groups       []string `mapstructure:"groups"`        // the visitor must be in one of these groups
+}
+
+// Required reports whether visitors have to sign in
+func (o OIDCAccess) Required() bool {
+	return o.Enabled || len(o.EmailDomains) > 0 || len(o.Groups) > 0
+}
+
+// CORSConfig is how the server handles the cross-origin requests of a tunnel
+type CORSConfig struct {
+	Mode             string        `mapstructure:"mode"`          // passthrough, off or policy, implied policy when origins are set
+	AllowOrigins     []string      `mapstructure:"allow_origins"` // "*", full origins or wildcards like https://*.example.com
+	AllowMethods     []string      `mapstructure:"allow_methods"`
+	AllowHeaders     []string      `mapstructure:"allow_headers"`
+	ExposeHeaders    []string      `mapstructure:"expose_headers"`
+	AllowCredentials bool          `mapstructure:"allow_credentials"`
+	MaxAge           time.Duration `mapstructure:"max_age"`
+}
+
+// Policy returns the CORS policy to ask the server for, nil to use the server default
+func (c CORSConfig) Policy() *protocol.CORSPolicy {
+	mode := c.Mode
+	if mode == "" {
+		if len(c.AllowOrigins) == 0 {
+			return nil
+		}
+		mode = protocol.CORSModePolicy
+	}
+	return &protocol.CORSPolicy{
+		Mode:             mode,
+		AllowOrigins:     c.AllowOrigins,
+		AllowMethods:     c.AllowMethods,
+		AllowHeaders:     c.AllowHeaders,
+		ExposeHeaders:    c.ExposeHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAgeSeconds:    int(c.MaxAge / time.Second),
+	}
+}
+
+// Validate checks the config the way the server will
+func (c CORSConfig) Validate() error {
+	if policy := c.Policy(); policy != nil {
+		return protocol.ValidateCORS(*policy)
+	}
+	return nil
+}
+
+// HeaderRule changes one header, Value can use placeholders like "{client_ip}"
+type HeaderRule struct {
+	Action string `mapstructure:"action"` // add, set or remove
+	Name   string `mapstructure:"name"`
+	Value  string `mapstructure:"value"`
+}
+
+// HeaderRules are applied in order, before the ones of the server's token policy
+type HeaderRules struct {
+	Request  []HeaderRule `mapstructure:"request"`  // before requests reach the local service
+	Response []HeaderRule `mapstructure:"response"` // before responses are returned to the public client
+}
+
+// Rules returns the rules to send to the server
+func (r HeaderRules) Rules() protocol.HeaderRules {
+	return protocol.HeaderRules{Request: convertHeaderRules(r.Request), Response: convertHeaderRules(r.Response)}
+}
+
+func convertHeaderRules(list []HeaderRule) []protocol.HeaderRule {
+	rules := make([]protocol.HeaderRule, 0, len(list))
+	for _, r := range list {
+		rules = append(rules, protocol.HeaderRule{Action: r.Action, Name: r.Name, Value: r.Value})
+	}
+	return rules
+}
+
+// PathConfig is how the server maps public paths to the paths of the local service
+type PathConfig struct {
+	Mode              string        `mapstructure:"mode"`     // strip (default), preserve or rewrite, implied rewrite when rules are set
+	Rewrites          []PathRewrite `mapstructure:"rewrites"` // the first matching rule applies to the path without the prefix
+	RewriteLocation   bool          `mapstructure:"rewrite_location"`
+	RewriteCookiePath bool          `mapstructure:"rewrite_cookie_path"`
+}
+
+// PathRewrite replaces a path matching a regular expression, Replace can use $1 or ${name}
+type PathRewrite struct {
+	Match   string `mapstructure:"match"`
+	Replace string `mapstructure:"replace"`
+}
+
+// Options returns the path options to send to the server, nil when the defaults are kept
+func (c PathConfig) Options(upstreamHost string) *protocol.PathOptions {
+	mode := c.Mode
+	if mode == "" && len(c.Rewrites) > 0 {
+		mode = protocol.PathModeRewrite
+	}
+	if mode == "" && !c.RewriteLocation && !c.RewriteCookiePath {
+		return nil
+	}
+	options := &protocol.PathOptions{
+		Mode:              mode,
+		RewriteLocation:   c.RewriteLocation,
+		RewriteCookiePath: c.RewriteCookiePath,
+		UpstreamHost:      upstreamHost,
+	}
+	for _, r := range c.Rewrites {
+		options.Rewrites = append(options.Rewrites, protocol.PathRewrite{Match: r.Match, Replace: r.Replace})
+	}
+	return options
+}
+
+// Validate checks the config the way the server will
+func (c PathConfig) Validate() error {
+	if options := c.Options(""); options != nil {
+		return protocol.ValidatePathOptions(*options)
+	}
+	return nil
+}
+
+// TLSConfig controls how the client verifies a TLS peer: an https:// local service or the server
+type TLSConfig struct {
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	CAFile             string `mapstructure:"ca_file"`     // PEM bundle trusted in addition to the system roots
+	ServerName         string `mapstructure:"server_name"` // SNI and verification name, defaults to the peer host
+}
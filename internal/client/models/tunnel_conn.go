@@ -1,12 +1,38 @@
 package models
 
 import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/inspector"
+	"github.com/B-AJ-Amar/gTunnel/internal/har"
 	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
 )
 
 type ClientTunnelConn struct {
-	ID   string
-	Conn *websocket.Conn
-	Port string
-	Host string
+	ID       string
+	Conn     *websocket.Conn
+	Upstream *url.URL     // scheme, host:port and optional path prefix of the local service
+	Config   TunnelConfig // options the tunnel was started with
+	BaseURL  string
+	Log      *logrus.Entry // carries tunnel_id, base_url and remote_addr
+
+	HTTPClient      *http.Client     // client used to reach the local service
+	ResponseTimeout time.Duration    // response timeout the server applies to this tunnel
+	MaxResponseBody int64            // largest body the server accepts from the local service, 0 for no limit
+	MaxMessageSize  int64            // largest websocket message the server accepts, 0 for no limit
+	Inspector       *inspector.Store // captured traffic for the inspector UI, nil when disabled
+	HAR             *har.Writer      // records the tunnel's traffic, nil when recording is off
+
+	writeMu sync.Mutex
+}
+
+// WriteMessage serializes writes to the websocket, gorilla allows only one concurrent writer
+func (t *ClientTunnelConn) WriteMessage(messageType int, data []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.Conn.WriteMessage(messageType, data)
 }
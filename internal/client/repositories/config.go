@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
 	"github.com/spf13/viper"
 )
 
@@ -31,10 +33,24 @@ type ClientConfigRepository interface {
 	SetConfigValue(key string, value interface{}) error
 
 	GetConfigPath() string
+
+	GetProjectPath() string
+
+	ResolveAccessToken(config *models.ClientConfig) error
+
+	SaveProfile(name string, profile models.Profile) error
+
+	RemoveProfile(name string) error
+
+	SetCurrentProfile(name string) error
 }
 
 type ClientConfigRepo struct {
 	configPath string
+
+	// the project file is kept apart from the user config so saving never writes its values back
+	projectPath string
+	project     *viper.Viper
 }
 
 func NewClientConfigRepo() ClientConfigRepository {
@@ -80,6 +96,26 @@ func (r *ClientConfigRepo) InitConfig() error {
 		}
 	}
 
+	if path, explicit := projectFilePath(); path != "" {
+		project, err := loadProjectFile(path, explicit || projectEnvExpansion)
+		if err != nil {
+			return err
+		}
+		if !explicit {
+			var ignored []string
+			project, ignored = restrictProjectFile(project)
+			if len(ignored) > 0 {
+				logger.Warnf("Ignoring %s in %s, a project file found in the working tree can only set %s (pass it with --config to use them)",
+					strings.Join(ignored, ", "), path, strings.Join(discoveredProjectKeys, ", "))
+			}
+			if err := checkProjectPaths(project, path); err != nil {
+				return err
+			}
+		}
+		r.projectPath = path
+		r.project = project
+	}
+
 	return nil
 }
 
@@ -90,6 +126,13 @@ func (r *ClientConfigRepo) Load() (*models.ClientConfig, error) {
 		return nil, fmt.Errorf("could not unmarshal config: %w", err)
 	}
 
+	// project values take precedence, tunnels with the same name are replaced as a whole
+	if r.project != nil {
+		if err := r.project.Unmarshal(&config); err != nil {
+			return nil, fmt.Errorf("could not unmarshal project file %s: %w", r.projectPath, err)
+		}
+	}
+
 	return &config, nil
 }
 
@@ -147,3 +190,8 @@ func (r *ClientConfigRepo) SetConfigValue(key string, value interface{}) error {
 func (r *ClientConfigRepo) GetConfigPath() string {
 	return filepath.Join(r.configPath, configName+"."+configType)
 }
+
+// GetProjectPath returns the project file merged over the user config, "" when there is none
+func (r *ClientConfigRepo) GetProjectPath() string {
+	return r.projectPath
+}
@@ -0,0 +1,177 @@
+package repositories
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// SaveProfile adds a profile to the user config, replacing any profile with the same name
+func (r *ClientConfigRepo) SaveProfile(name string, profile models.Profile) error {
+	return r.editConfigFile(func(root *yaml.Node) error {
+		var value yaml.Node
+		if err := value.Encode(profileValue(profile)); err != nil {
+			return err
+		}
+		setMappingKey(mappingKey(root, "profiles"), name, &value)
+		return nil
+	})
+}
+
+// RemoveProfile deletes a profile from the user config, and unsets it if it was the current one
+func (r *ClientConfigRepo) RemoveProfile(name string) error {
+	return r.editConfigFile(func(root *yaml.Node) error {
+		profiles := lookupMappingKey(root, "profiles")
+		if profiles == nil || !deleteMappingKey(profiles, name) {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+		if current := lookupMappingKey(root, "current_profile"); current != nil && current.Value == name {
+			deleteMappingKey(root, "current_profile")
+		}
+		return nil
+	})
+}
+
+// SetCurrentProfile makes a profile the one used by default
+func (r *ClientConfigRepo) SetCurrentProfile(name string) error {
+	return r.editConfigFile(func(root *yaml.Node) error {
+		profiles := lookupMappingKey(root, "profiles")
+		if profiles == nil || lookupMappingKey(profiles, name) == nil {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+		setMappingKey(root, "current_profile", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name})
+		return nil
+	})
+}
+
+// editConfigFile applies edit to the user config file and reloads it. The file is edited
+// as a YAML document rather than through viper, which can't remove keys.
+func (r *ClientConfigRepo) editConfigFile(edit func(root *yaml.Node) error) error {
+	path := r.GetConfigPath()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("could not parse config file: %w", err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s is not a YAML mapping", path)
+	}
+
+	if err := edit(root); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return fmt.Errorf("could not encode config file: %w", err)
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0600); err != nil {
+		return fmt.Errorf("could not write config file: %w", err)
+	}
+	return viper.ReadInConfig()
+}
+
+// profileValue returns the settings of a profile as written to the config file, leaving out unset ones
+func profileValue(p models.Profile) map[string]interface{} {
+	value := map[string]interface{}{}
+	if p.ServerURL != "" {
+		value["server_url"] = p.ServerURL
+	}
+	if p.AccessToken != "" {
+		value["access_token"] = p.AccessToken
+	}
+	if p.TokenFile != "" {
+		value["token_file"] = p.TokenFile
+	}
+	if p.TokenCommand != "" {
+		value["token_command"] = p.TokenCommand
+	}
+	if p.TokenCommandTTL > 0 {
+		value["token_command_ttl"] = p.TokenCommandTTL.String()
+	}
+	if p.TokenCache {
+		value["token_cache"] = true
+	}
+
+	tls := map[string]interface{}{}
+	if p.TLS.InsecureSkipVerify {
+		tls["insecure_skip_verify"] = true
+	}
+	if p.TLS.CAFile != "" {
+		tls["ca_file"] = p.TLS.CAFile
+	}
+	if p.TLS.ServerName != "" {
+		tls["server_name"] = p.TLS.ServerName
+	}
+	if len(tls) > 0 {
+		value["tls"] = tls
+	}
+
+	timeouts := map[string]interface{}{}
+	if p.Timeouts.Upstream > 0 {
+		timeouts["upstream"] = p.Timeouts.Upstream.String()
+	}
+	if p.Timeouts.Ping > 0 {
+		timeouts["ping"] = p.Timeouts.Ping.String()
+	}
+	if p.Timeouts.Response > 0 {
+		timeouts["response"] = p.Timeouts.Response.String()
+	}
+	if len(timeouts) > 0 {
+		value["timeouts"] = timeouts
+	}
+	return value
+}
+
+func lookupMappingKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingKey returns the mapping under key, creating it (or replacing a non-mapping value) if needed
+func mappingKey(mapping *yaml.Node, key string) *yaml.Node {
+	if value := lookupMappingKey(mapping, key); value != nil && value.Kind == yaml.MappingNode {
+		return value
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode}
+	setMappingKey(mapping, key, value)
+	return value
+}
+
+func setMappingKey(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func deleteMappingKey(mapping *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
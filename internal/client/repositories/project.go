@@ -0,0 +1,232 @@
+package repositories
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/spf13/viper"
+)
+
+// ProjectFileName is the project-local config merged over the user config
+const ProjectFileName = ".gtunnel.yaml"
+
+// projectFileOverride is the project file given with --config, it disables the lookup
+var projectFileOverride string
+
+// projectEnvExpansion allows ${VAR} references in a discovered project file, see SetProjectEnvExpansion
+var projectEnvExpansion bool
+
+// discoveredProjectKeys are the settings a .gtunnel.yaml found by FindProjectFile may set.
+// A cloned repository must not be able to pick the server the token is sent to, run a
+// token_command or write files outside of it, so credentials, servers, profiles, logging and
+// HAR redaction are only read from the user config or a file given explicitly with --config.
+var discoveredProjectKeys = []string{"tunnels", "inspector", "timeouts"}
+
+// SetProjectFile makes the repositories use path as the project file instead of looking for one
+func SetProjectFile(path string) {
+	projectFileOverride = path
+}
+
+// SetProjectEnvExpansion expands ${VAR} references in a discovered project file too. They are
+// left as they are by default, as a cloned repository could send environment secrets in headers.
+func SetProjectEnvExpansion(enabled bool) {
+	projectEnvExpansion = enabled
+}
+
+// FindProjectFile looks for a .gtunnel.yaml in dir and its parents, it returns "" when there is none
+func FindProjectFile(dir string) string {
+	for {
+		path := filepath.Join(dir, ProjectFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// projectFilePath returns the project file in use: the --config one or the closest .gtunnel.yaml.
+// explicit is true for the --config one.
+func projectFilePath() (path string, explicit bool) {
+	if projectFileOverride != "" {
+		return projectFileOverride, true
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+	return FindProjectFile(wd), false
+}
+
+// restrictProjectFile keeps only the discoveredProjectKeys of a project file and returns the
+// other top-level keys it had, sorted
+func restrictProjectFile(project *viper.Viper) (*viper.Viper, []string) {
+	allowed := make(map[string]bool, len(discoveredProjectKeys))
+	restricted := viper.New()
+	for _, key := range discoveredProjectKeys {
+		allowed[key] = true
+		if project.IsSet(key) {
+			restricted.Set(key, project.Get(key))
+		}
+	}
+
+	var ignored []string
+	for key := range project.AllSettings() {
+		if !allowed[key] {
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+	return restricted, ignored
+}
+
+// checkProjectPaths rejects the tunnel HAR files of a discovered project file that are
+// outside of the directory holding it
+func checkProjectPaths(project *viper.Viper, path string) error {
+	dir, err := resolvePath(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("could not resolve the project directory: %w", err)
+	}
+
+	var tunnels map[string]models.TunnelConfig
+	if err := project.UnmarshalKey("tunnels", &tunnels); err != nil {
+		return fmt.Errorf("could not unmarshal project file %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(tunnels))
+	for name := range tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		har := tunnels[name].HAR
+		if har == "" {
+			continue
+		}
+		resolved, err := resolvePath(filepath.Dir(har))
+		if err != nil {
+			return fmt.Errorf("%s: tunnels.%s.har: %w", path, name, err)
+		}
+		rel, err := filepath.Rel(dir, filepath.Join(resolved, filepath.Base(har)))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s: tunnels.%s.har: %s is outside of the project directory (pass the file with --config to allow it)", path, name, har)
+		}
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path of path with the symlinks of its existing part resolved
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(abs)}, missing...)
+		abs = parent
+	}
+}
+
+// loadProjectFile reads a project file into its own viper instance, after environment
+// interpolation when expand is set
+func loadProjectFile(path string, expand bool) (*viper.Viper, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read project file: %w", err)
+	}
+
+	if expand {
+		if data, err = ExpandEnv(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else if envPattern.Match(data) {
+		logger.Warnf("Not expanding the environment references in %s (pass --expand-env to expand them)", path)
+	}
+
+	v := viper.New()
+	v.SetConfigType(configType)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("could not parse project file %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// EnvError reports a required environment variable that is not set
+type EnvError struct {
+	Line    int
+	Name    string
+	Message string
+}
+
+func (e *EnvError) Error() string {
+	return fmt.Sprintf("line %d: %s %s", e.Line, e.Name, e.Message)
+}
+
+// envPattern matches $$, ${VAR}, ${VAR:-default} and ${VAR:?message}
+var envPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:-|:\?)([^}]*))?\}`)
+
+// ExpandEnv replaces ${VAR} references with the value of the environment variable.
+// ${VAR:-default} falls back to default when VAR is unset or empty, ${VAR:?message}
+// fails with message instead, and $$ is a literal $.
+func ExpandEnv(data []byte) ([]byte, error) {
+	var expandErr *EnvError
+	expanded := envPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		if string(match) == "$$" {
+			return []byte("$")
+		}
+
+		groups := envPattern.FindSubmatch(match)
+		name, op, arg := string(groups[1]), string(groups[2]), string(groups[3])
+		if value := os.Getenv(name); value != "" {
+			return []byte(value)
+		}
+
+		switch op {
+		case ":-":
+			return []byte(arg)
+		case ":?":
+			if expandErr == nil {
+				if arg == "" {
+					arg = "is not set"
+				}
+				expandErr = &EnvError{Line: lineOf(data, match), Name: name, Message: arg}
+			}
+		}
+		return nil
+	})
+	if expandErr != nil {
+		return nil, expandErr
+	}
+	return expanded, nil
+}
+
+// lineOf returns the line of the first occurrence of match in data
+func lineOf(data, match []byte) int {
+	i := bytes.Index(data, match)
+	if i < 0 {
+		return 0
+	}
+	return bytes.Count(data[:i], []byte("\n")) + 1
+}
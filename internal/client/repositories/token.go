@@ -0,0 +1,189 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+)
+
+const (
+	// AccessTokenEnv overrides every other token source
+	AccessTokenEnv = "GTUNNEL_ACCESS_TOKEN"
+
+	defaultTokenCommandTTL = 5 * time.Minute
+	tokenCommandTimeout    = 30 * time.Second
+)
+
+// cachedToken is the output of a token command kept between runs
+type cachedToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// helperOutput is the JSON a token command may print instead of a bare token
+type helperOutput struct {
+	Token     string    `json:"token"`
+	ExpiresIn int64     `json:"expires_in"` // seconds
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ResolveAccessToken sets the access token of the config from, in order: the
+// GTUNNEL_ACCESS_TOKEN environment variable, token_file, token_command and access_token.
+// The source is recorded in config.TokenSource.
+func (r *ClientConfigRepo) ResolveAccessToken(config *models.ClientConfig) error {
+	if token := os.Getenv(AccessTokenEnv); token != "" {
+		config.AccessToken = token
+		config.TokenSource = "environment (" + AccessTokenEnv + ")"
+		return nil
+	}
+
+	if config.TokenFile != "" {
+		path := ExpandHome(config.TokenFile)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read token file: %w", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return fmt.Errorf("token file %s is empty", path)
+		}
+		config.AccessToken = token
+		config.TokenSource = "file " + path
+		return nil
+	}
+
+	if config.TokenCommand != "" {
+		ttl := config.TokenCommandTTL
+		if ttl <= 0 {
+			ttl = defaultTokenCommandTTL
+		}
+		token, cached, err := r.commandToken(config.TokenCommand, ttl, config.TokenCache)
+		if err != nil {
+			return err
+		}
+		config.AccessToken = token
+		config.TokenSource = "command " + config.TokenCommand
+		if cached {
+			config.TokenSource += " (cached)"
+		}
+		return nil
+	}
+
+	if config.AccessToken != "" {
+		config.TokenSource = "config file"
+		return nil
+	}
+	if profile, ok := config.Profiles[config.CurrentProfile]; ok && profile.ServerURL != "" {
+		return fmt.Errorf("profile %s has no token, set its access_token, token_file or token_command or run 'gtc login --profile %s'", config.CurrentProfile, config.CurrentProfile)
+	}
+	return nil
+}
+
+// commandToken returns the token printed by a credential helper. With useCache, its output
+// is kept on disk and reused until it expires. Without it, a cache file left from when it was
+// on is removed, so the token does not stay on disk in plaintext.
+func (r *ClientConfigRepo) commandToken(command string, ttl time.Duration, useCache bool) (string, bool, error) {
+	cachePath := tokenCachePath(command)
+	if cachePath != "" && !useCache {
+		os.Remove(cachePath)
+		cachePath = ""
+	}
+	if cachePath != "" {
+		if data, err := os.ReadFile(cachePath); err == nil {
+			var cached cachedToken
+			if json.Unmarshal(data, &cached) == nil && cached.Token != "" && time.Now().Before(cached.ExpiresAt) {
+				return cached.Token, true, nil
+			}
+		}
+	}
+
+	token, expiresAt, err := runTokenCommand(command, ttl)
+	if err != nil {
+		return "", false, err
+	}
+
+	if cachePath != "" {
+		// the cache only saves running the helper again, failing to write it is not an error
+		if data, err := json.Marshal(cachedToken{Token: token, ExpiresAt: expiresAt}); err == nil {
+			if os.MkdirAll(filepath.Dir(cachePath), 0700) == nil {
+				os.WriteFile(cachePath, data, 0600)
+			}
+		}
+	}
+	return token, false, nil
+}
+
+// runTokenCommand runs a credential helper through the shell. It prints either the token,
+// or a JSON object with "token" and optionally "expires_in" (seconds) or "expires_at".
+func runTokenCommand(command string, ttl time.Duration) (string, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr // helpers may prompt or explain failures
+	cmd.Stdin = os.Stdin
+
+	if err := cmd.Run(); err != nil {
+		return "", time.Time{}, fmt.Errorf("token command failed: %w", err)
+	}
+
+	output := strings.TrimSpace(stdout.String())
+	expiresAt := time.Now().Add(ttl)
+
+	if strings.HasPrefix(output, "{") {
+		var parsed helperOutput
+		if err := json.Unmarshal([]byte(output), &parsed); err != nil {
+			return "", time.Time{}, fmt.Errorf("invalid token command output: %w", err)
+		}
+		output = parsed.Token
+		switch {
+		case parsed.ExpiresIn > 0:
+			expiresAt = time.Now().Add(time.Duration(parsed.ExpiresIn) * time.Second)
+		case !parsed.ExpiresAt.IsZero():
+			expiresAt = parsed.ExpiresAt
+		}
+	}
+
+	if output == "" {
+		return "", time.Time{}, fmt.Errorf("token command printed no token")
+	}
+	return output, expiresAt, nil
+}
+
+// tokenCachePath returns the cache file for a token command, "" when there is no cache directory
+func tokenCachePath(command string) string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(command))
+	return filepath.Join(cacheDir, appName, "token-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// ExpandHome replaces a leading ~ in a path with the home directory
+func ExpandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
@@ -0,0 +1,245 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigIssue is a problem found in a config file, Line and Column are 1-based (0 when unknown)
+type ConfigIssue struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (i ConfigIssue) String() string {
+	switch {
+	case i.Line > 0 && i.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", i.File, i.Line, i.Column, i.Message)
+	case i.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", i.File, i.Line, i.Message)
+	default:
+		return fmt.Sprintf("%s: %s", i.File, i.Message)
+	}
+}
+
+// ValueCheck validates a scalar config value
+type ValueCheck func(value string) error
+
+// valueChecks are applied to the values at these paths, "*" matches any map key
+var valueChecks = map[string]ValueCheck{
+	"log.level": func(value string) error {
+		return logger.ValidateLevel(logger.LogLevel(value))
+	},
+	"log.format": func(value string) error {
+		if value != "" && value != string(logger.FormatText) && value != string(logger.FormatJSON) {
+			return fmt.Errorf("invalid log format %q (expected text or json)", value)
+		}
+		return nil
+	},
+}
+
+// requiredKeys lists the keys a mapping at these paths must have
+var requiredKeys = map[string][]string{
+	"tunnels.*": {"upstream"},
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	yamlLine     = regexp.MustCompile(`^line (\d+)`)
+)
+
+// ValidateConfigFile checks a client config or project file against the config schema: unknown keys,
+// wrong value types, invalid durations and values. Extra checks can be given for paths such as
+// "tunnels.*.upstream". Environment references are expanded first, as when the file is loaded.
+func ValidateConfigFile(path string, checks map[string]ValueCheck) ([]ConfigIssue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &validator{file: path, checks: map[string]ValueCheck{}}
+	for pattern, check := range valueChecks {
+		v.checks[pattern] = check
+	}
+	for pattern, check := range checks {
+		v.checks[pattern] = check
+	}
+
+	expanded, err := ExpandEnv(data)
+	var envErr *EnvError
+	if errors.As(err, &envErr) {
+		return []ConfigIssue{{File: path, Line: envErr.Line, Message: fmt.Sprintf("environment variable %s %s", envErr.Name, envErr.Message)}}, nil
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(expanded, &root); err != nil {
+		issue := ConfigIssue{File: path, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := yamlLine.FindStringSubmatch(issue.Message); m != nil {
+			issue.Line, _ = strconv.Atoi(m[1])
+			issue.Message = strings.TrimPrefix(issue.Message, m[0]+": ")
+		}
+		return []ConfigIssue{issue}, nil
+	}
+	if len(root.Content) == 0 {
+		return nil, nil // empty file
+	}
+
+	v.validate(root.Content[0], reflect.TypeOf(models.ClientConfig{}), nil, nil)
+	sort.SliceStable(v.issues, func(i, j int) bool { return v.issues[i].Line < v.issues[j].Line })
+	return v.issues, nil
+}
+
+type validator struct {
+	file   string
+	checks map[string]ValueCheck
+	issues []ConfigIssue
+}
+
+func (v *validator) add(node *yaml.Node, format string, args ...interface{}) {
+	issue := ConfigIssue{File: v.file, Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		issue.Line, issue.Column = node.Line, node.Column
+	}
+	v.issues = append(v.issues, issue)
+}
+
+// validate checks node against the Go type t. path holds the keys leading to it with "*" for
+// map keys, as used by checks, and keys the same keys as written in the file, for messages.
+func (v *validator) validate(node *yaml.Node, t reflect.Type, path, keys []string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+	pattern := strings.Join(path, ".")
+	name := strings.Join(keys, ".")
+
+	switch {
+	case t == durationType:
+		if v.scalar(node, name, "a duration (e.g. 30s)") {
+			if _, err := time.ParseDuration(node.Value); err != nil {
+				v.add(node, "%s: invalid duration %q (e.g. 30s, 2m, 1h)", name, node.Value)
+			}
+		}
+		return
+
+	case t.Kind() == reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.add(node, "%s: expected a mapping", name)
+			return
+		}
+		fields := structFields(t)
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				v.add(key, "unknown field %q%s", key.Value, pathSuffix(name))
+				continue
+			}
+			seen[key.Value] = true
+			v.validate(value, field, append(path, key.Value), append(keys, key.Value))
+		}
+		for _, required := range requiredKeys[pattern] {
+			if !seen[required] {
+				v.add(node, "%s: missing required field %q", name, required)
+			}
+		}
+		return
+
+	case t.Kind() == reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.add(node, "%s: expected a mapping", name)
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.validate(node.Content[i+1], t.Elem(), append(path, "*"), append(keys, node.Content[i].Value))
+		}
+		return
+
+	case t.Kind() == reflect.Slice:
+		switch node.Kind {
+		case yaml.SequenceNode:
+			for _, item := range node.Content {
+				v.validate(item, t.Elem(), path, keys)
+			}
+		case yaml.ScalarNode:
+			// a comma separated string is accepted for lists
+		default:
+			v.add(node, "%s: expected a list", name)
+		}
+		return
+
+	case t.Kind() == reflect.Bool:
+		if v.scalar(node, name, "true or false") {
+			if _, err := strconv.ParseBool(node.Value); err != nil {
+				v.add(node, "%s: expected true or false, got %q", name, node.Value)
+			}
+		}
+		return
+
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		if v.scalar(node, name, "a number") {
+			if _, err := strconv.Atoi(node.Value); err != nil {
+				v.add(node, "%s: expected a number, got %q", name, node.Value)
+			}
+		}
+		return
+
+	case t.Kind() == reflect.String:
+		if !v.scalar(node, name, "a string") {
+			return
+		}
+		if check, ok := v.checks[pattern]; ok {
+			if err := check(node.Value); err != nil {
+				v.add(node, "%s: %v", name, err)
+			}
+		}
+	}
+}
+
+func (v *validator) scalar(node *yaml.Node, name, expected string) bool {
+	if node.Kind != yaml.ScalarNode {
+		v.add(node, "%s: expected %s", name, expected)
+		return false
+	}
+	return true
+}
+
+// structFields maps the mapstructure keys of a struct to their types
+func structFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = strings.ToLower(field.Name)
+		}
+		fields[tag] = field.Type
+	}
+	return fields
+}
+
+func pathSuffix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return " in " + name
+}
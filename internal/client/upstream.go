@@ -0,0 +1,105 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/client/models"
+)
+
+// ParseUpstream turns the connect target into the URL of the local service.
+// It accepts a port (3000), host:port (myapp.local:3000) or a full URL (https://localhost:8443/api).
+func ParseUpstream(target string) (*url.URL, error) {
+	if target == "" {
+		return nil, fmt.Errorf("upstream is empty")
+	}
+
+	if !strings.Contains(target, "://") {
+		if !strings.Contains(target, ":") {
+			target = "localhost:" + target
+		}
+		target = "http://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported upstream scheme %q (expected http or https)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid upstream %q: missing host", target)
+	}
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			u.Host = u.Host + ":443"
+		} else {
+			u.Host = u.Host + ":80"
+		}
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u, nil
+}
+
+// NewTLSConfig returns the crypto/tls settings for a TLS config from the client config
+func NewTLSConfig(cfg models.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		ServerName:         cfg.ServerName,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// newUpstreamClient builds the HTTP client used to reach the local service.
+// Without an explicit upstream timeout the tunnel response timeout is used, there is no point
+// waiting for the local service after the server gave up on the response.
+// Redirects are returned to the caller as-is instead of being followed.
+func newUpstreamClient(tunnel *models.ClientTunnelConn, tlsCfg models.TLSConfig, timeouts models.TimeoutConfig) (*http.Client, error) {
+	timeout := timeouts.Upstream
+	if timeout <= 0 {
+		timeout = tunnel.ResponseTimeout
+	}
+
+	tlsConfig, err := NewTLSConfig(tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
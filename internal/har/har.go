@@ -0,0 +1,252 @@
+// Package har writes tunneled traffic as HAR 1.2 files (http://www.softwareishard.com/blog/har-12-spec/)
+// that can be opened in browser devtools.
+package har
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	Version            = "1.2"
+	DefaultMaxBodySize = 1 << 20 // 1 MiB written per body
+	Redacted           = "[REDACTED]"
+)
+
+// DefaultRedactHeaders are the headers whose values are never written unless configured otherwise
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Options controls what is written for each entry
+type Options struct {
+	MaxBodySize   int      // bytes written per body, bodies are truncated past it (0 uses DefaultMaxBodySize)
+	RedactHeaders []string // header names whose values are replaced by [REDACTED] (nil uses DefaultRedactHeaders)
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxBodySize <= 0 {
+		o.MaxBodySize = DefaultMaxBodySize
+	}
+	if o.RedactHeaders == nil {
+		o.RedactHeaders = DefaultRedactHeaders
+	}
+	return o
+}
+
+func (o Options) redacted(name string) bool {
+	for _, h := range o.RedactHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record is one request/response pair to write to a HAR file
+type Record struct {
+	StartedAt time.Time
+	Duration  time.Duration
+
+	Method         string
+	URL            string
+	RequestHeaders http.Header
+	RequestBody    []byte
+
+	Status          int // 0 when no response was received
+	ResponseHeaders http.Header
+	ResponseBody    []byte
+
+	Error   string // why no response was received
+	Comment string
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+	Error       string      `json:"_error,omitempty"` // same custom field as Chrome for failed requests
+}
+
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type Entry struct {
+	StartedDateTime string   `json:"startedDateTime"`
+	Time            float64  `json:"time"`
+	Request         Request  `json:"request"`
+	Response        Response `json:"response"`
+	Cache           struct{} `json:"cache"`
+	Timings         Timings  `json:"timings"`
+	Comment         string   `json:"comment,omitempty"`
+}
+
+// NewEntry converts a record to a HAR entry, applying the body limit and header redaction
+func NewEntry(rec Record, opts Options) Entry {
+	opts = opts.withDefaults()
+	ms := float64(rec.Duration.Microseconds()) / 1000
+
+	entry := Entry{
+		StartedDateTime: rec.StartedAt.Format(time.RFC3339Nano),
+		Time:            ms,
+		Request:         newRequest(rec, opts),
+		Response:        newResponse(rec, opts),
+		Timings:         Timings{Wait: ms},
+		Comment:         rec.Comment,
+	}
+	return entry
+}
+
+func newRequest(rec Record, opts Options) Request {
+	req := Request{
+		Method:      rec.Method,
+		URL:         rec.URL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []Cookie{},
+		Headers:     headerList(rec.RequestHeaders, opts),
+		QueryString: []NameValue{},
+		HeadersSize: -1,
+		BodySize:    len(rec.RequestBody),
+	}
+
+	if u, err := url.Parse(rec.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				req.QueryString = append(req.QueryString, NameValue{Name: name, Value: value})
+			}
+		}
+		sort.Slice(req.QueryString, func(i, j int) bool { return req.QueryString[i].Name < req.QueryString[j].Name })
+	}
+
+	if !opts.redacted("Cookie") {
+		for _, c := range (&http.Request{Header: rec.RequestHeaders}).Cookies() {
+			req.Cookies = append(req.Cookies, Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+
+	if len(rec.RequestBody) > 0 {
+		text, encoding, comment := bodyText(rec.RequestBody, opts.MaxBodySize)
+		if encoding != "" {
+			comment = strings.TrimPrefix(comment+"; base64 encoded", "; ")
+		}
+		req.PostData = &PostData{
+			MimeType: rec.RequestHeaders.Get("Content-Type"),
+			Text:     text,
+			Comment:  comment,
+		}
+	}
+	return req
+}
+
+func newResponse(rec Record, opts Options) Response {
+	resp := Response{
+		Status:      rec.Status,
+		StatusText:  http.StatusText(rec.Status),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []Cookie{},
+		Headers:     headerList(rec.ResponseHeaders, opts),
+		Content: Content{
+			Size:     len(rec.ResponseBody),
+			MimeType: rec.ResponseHeaders.Get("Content-Type"),
+		},
+		RedirectURL: rec.ResponseHeaders.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(rec.ResponseBody),
+		Error:       rec.Error,
+	}
+	if rec.Status == 0 {
+		resp.HTTPVersion = ""
+		resp.BodySize = -1
+	}
+
+	if !opts.redacted("Set-Cookie") {
+		for _, c := range (&http.Response{Header: rec.ResponseHeaders}).Cookies() {
+			resp.Cookies = append(resp.Cookies, Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+
+	if len(rec.ResponseBody) > 0 {
+		resp.Content.Text, resp.Content.Encoding, resp.Content.Comment = bodyText(rec.ResponseBody, opts.MaxBodySize)
+	}
+	return resp
+}
+
+func headerList(headers http.Header, opts Options) []NameValue {
+	list := []NameValue{}
+	for name, values := range headers {
+		for _, value := range values {
+			if opts.redacted(name) {
+				value = Redacted
+			}
+			list = append(list, NameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// bodyText returns the body as text, base64 encoded when it isn't valid UTF-8, truncated to max bytes
+func bodyText(body []byte, max int) (text, encoding, comment string) {
+	if len(body) > max {
+		comment = fmt.Sprintf("truncated to %d of %d bytes", max, len(body))
+		body = body[:max]
+	}
+	if utf8.Valid(body) {
+		return string(body), "", comment
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64", comment
+}
@@ -0,0 +1,103 @@
+package har
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// the file is kept valid after every entry: entries are written before the
+// trailer, which is rewritten each time
+const trailer = "\n]}}\n"
+
+// Writer appends entries to a HAR file as they happen
+type Writer struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	opts    Options
+	entries int
+}
+
+// Create creates (or truncates) the HAR file at path
+func Create(path string, creator Creator, opts Options) (*Writer, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("could not create HAR directory: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not create HAR file: %w", err)
+	}
+
+	creatorJSON, err := json.Marshal(creator)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	header := fmt.Sprintf(`{"log":{"version":%q,"creator":%s,"pages":[],"entries":[`, Version, creatorJSON)
+	if _, err := file.WriteString(header + trailer); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not write HAR file: %w", err)
+	}
+
+	return &Writer{path: path, file: file, opts: opts.withDefaults()}, nil
+}
+
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// Write adds a record to the file, it is safe to call on a nil Writer
+func (w *Writer) Write(rec Record) error {
+	if w == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(NewEntry(rec, w.opts))
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	if _, err := w.file.Seek(-int64(len(trailer)), io.SeekEnd); err != nil {
+		return err
+	}
+
+	sep := "\n"
+	if w.entries > 0 {
+		sep = ",\n"
+	}
+	if _, err := w.file.WriteString(sep + string(data) + trailer); err != nil {
+		return err
+	}
+	w.entries++
+	return nil
+}
+
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,212 @@
+package headers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+// maxValueLength caps a rendered header value, placeholders like {path} come from the request
+const maxValueLength = 4096
+
+// reserved headers are managed by the server, the client or net/http and cannot be changed by rules
+var reserved = map[string]string{
+	"Host":              "use the tunnel's host_header setting instead",
+	"Content-Length":    "it is set from the body",
+	"Transfer-Encoding": "it is set from the body",
+	"Connection":        "it is a hop-by-hop header",
+	"Upgrade":           "it is a hop-by-hop header",
+}
+
+// Vars are the values of the placeholders a rule value can use, e.g. "{client_ip}"
+type Vars struct {
+	ClientIP  string // public client, from X-Forwarded-For only behind a trusted proxy
+	TunnelID  string
+	RequestID string
+	BaseURL   string // tunnel prefix, without the leading slash
+	Host      string // public host
+	Method    string
+	Path      string // path sent to the local app
+}
+
+// placeholders are the names a rule value can use between braces
+var placeholders = map[string]func(Vars) string{
+	"client_ip":  func(v Vars) string { return v.ClientIP },
+	"tunnel_id":  func(v Vars) string { return v.TunnelID },
+	"request_id": func(v Vars) string { return v.RequestID },
+	"base_url":   func(v Vars) string { return v.BaseURL },
+	"host":       func(v Vars) string { return v.Host },
+	"method":     func(v Vars) string { return v.Method },
+	"path":       func(v Vars) string { return v.Path },
+}
+
+// part is a piece of a rule value, literal text or a placeholder
+type part struct {
+	text        string
+	placeholder func(Vars) string
+}
+
+type rule struct {
+	action string
+	name   string
+	value  []part
+}
+
+// Rules are compiled header rules, the zero value changes nothing
+type Rules struct {
+	request  []rule
+	response []rule
+}
+
+// Compile checks header rules and parses their value templates
+func Compile(rules protocol.HeaderRules) (Rules, error) {
+	request, err := compile(rules.Request)
+	if err != nil {
+		return Rules{}, fmt.Errorf("request header rule: %w", err)
+	}
+	response, err := compile(rules.Response)
+	if err != nil {
+		return Rules{}, fmt.Errorf("response header rule: %w", err)
+	}
+	return Rules{request: request, response: response}, nil
+}
+
+func compile(list []protocol.HeaderRule) ([]rule, error) {
+	compiled := make([]rule, 0, len(list))
+	for _, r := range list {
+		name := http.CanonicalHeaderKey(strings.TrimSpace(r.Name))
+		if !validName(name) {
+			return nil, fmt.Errorf("invalid header name %q", r.Name)
+		}
+		if why, ok := reserved[name]; ok {
+			return nil, fmt.Errorf("%s cannot be changed, %s", name, why)
+		}
+
+		c := rule{action: r.Action, name: name}
+		switch r.Action {
+		case protocol.HeaderActionRemove:
+			if r.Value != "" {
+				return nil, fmt.Errorf("remove %s takes no value", name)
+			}
+		case protocol.HeaderActionAdd, protocol.HeaderActionSet:
+			value, err := parseValue(r.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+			}
+			c.value = value
+		default:
+			return nil, fmt.Errorf("unknown action %q for %s, use %s, %s or %s", r.Action, name, protocol.HeaderActionAdd, protocol.HeaderActionSet, protocol.HeaderActionRemove)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// Then returns the rules of r followed by the ones of next, which win when both change a header
+func (r Rules) Then(next Rules) Rules {
+	return Rules{
+		request:  append(append([]rule{}, r.request...), next.request...),
+		response: append(append([]rule{}, r.response...), next.response...),
+	}
+}
+
+// Count returns the number of request and response rules
+func (r Rules) Count() (int, int) {
+	return len(r.request), len(r.response)
+}
+
+// ApplyRequest changes the headers of a request before it is sent to the local app
+func (r Rules) ApplyRequest(h http.Header, vars Vars) {
+	apply(r.request, h, vars)
+}
+
+// ApplyResponse changes the headers of a response before it is returned to the public client
+func (r Rules) ApplyResponse(h http.Header, vars Vars) {
+	apply(r.response, h, vars)
+}
+
+// parseValue splits a rule value into text and {name} placeholders. A brace that does not
+// start a known placeholder is an error, so typos and the old {{.Field}} syntax are caught.
+func parseValue(value string) ([]part, error) {
+	var parts []part
+	for value != "" {
+		open := strings.IndexAny(value, "{}")
+		if open < 0 {
+			parts = append(parts, part{text: value})
+			break
+		}
+		if value[open] == '}' {
+			return nil, fmt.Errorf("unexpected } in %q", value)
+		}
+		if open > 0 {
+			parts = append(parts, part{text: value[:open]})
+		}
+		end := strings.IndexByte(value[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in %q", value)
+		}
+		name := value[open+1 : open+end]
+		placeholder, ok := placeholders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s}, use {client_ip}, {tunnel_id}, {request_id}, {base_url}, {host}, {method} or {path}", name)
+		}
+		parts = append(parts, part{placeholder: placeholder})
+		value = value[open+end+1:]
+	}
+	return parts, nil
+}
+
+func render(parts []part, vars Vars) string {
+	var value strings.Builder
+	for _, p := range parts {
+		if p.placeholder != nil {
+			value.WriteString(p.placeholder(vars))
+		} else {
+			value.WriteString(p.text)
+		}
+	}
+	// values come from the request, they must not be able to start a new header or break it
+	v := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value.String())
+	if len(v) > maxValueLength {
+		v = strings.ToValidUTF8(v[:maxValueLength], "")
+	}
+	return v
+}
+
+func apply(rules []rule, h http.Header, vars Vars) {
+	for _, r := range rules {
+		if r.action == protocol.HeaderActionRemove {
+			h.Del(r.name)
+			continue
+		}
+
+		v := render(r.value, vars)
+		if r.action == protocol.HeaderActionSet {
+			h.Set(r.name, v)
+		} else {
+			h.Add(r.name, v)
+		}
+	}
+}
+
+// tokenChars are the punctuation allowed in header names besides letters and digits
+const tokenChars = "!#$%&'*+-.^_`|~"
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || strings.ContainsRune(tokenChars, c)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package headers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+func TestCompileValues(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"plain", false},
+		{"{client_ip}", false},
+		{"ip={client_ip}; id={request_id}", false},
+		{"", false},
+		{"{{.ClientIP}}", true},
+		{"{{range 20000000}}abcdefgh{{end}}", true},
+		{"{unknown}", true},
+		{"{client_ip", true},
+		{"client_ip}", true},
+	}
+	for _, tt := range tests {
+		_, err := Compile(protocol.HeaderRules{Request: []protocol.HeaderRule{{Action: protocol.HeaderActionSet, Name: "X-Test", Value: tt.value}}})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Compile(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestApplyRequest(t *testing.T) {
+	vars := Vars{ClientIP: "203.0.113.7", RequestID: "req-1", Path: "/a\r\nX-Injected: 1\x00\x7f"}
+	tests := []struct {
+		name  string
+		rules []protocol.HeaderRule
+		in    http.Header
+		want  http.Header
+	}{
+		{
+			name:  "set replaces",
+			rules: []protocol.HeaderRule{{Action: "set", Name: "x-client", Value: "{client_ip}"}},
+			in:    http.Header{"X-Client": {"spoofed", "twice"}},
+			want:  http.Header{"X-Client": {"203.0.113.7"}},
+		},
+		{
+			name:  "add keeps",
+			rules: []protocol.HeaderRule{{Action: "add", Name: "X-Id", Value: "id-{request_id}"}},
+			in:    http.Header{"X-Id": {"first"}},
+			want:  http.Header{"X-Id": {"first", "id-req-1"}},
+		},
+		{
+			name:  "remove",
+			rules: []protocol.HeaderRule{{Action: "remove", Name: "Server"}},
+			in:    http.Header{"Server": {"app"}, "X-Other": {"1"}},
+			want:  http.Header{"X-Other": {"1"}},
+		},
+		{
+			name:  "control characters are stripped",
+			rules: []protocol.HeaderRule{{Action: "set", Name: "X-Path", Value: "{path}"}},
+			in:    http.Header{},
+			want:  http.Header{"X-Path": {"/aX-Injected: 1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Compile(protocol.HeaderRules{Request: tt.rules})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules.ApplyRequest(tt.in, vars)
+			if len(tt.in) != len(tt.want) {
+				t.Fatalf("got %v, want %v", tt.in, tt.want)
+			}
+			for name, values := range tt.want {
+				if strings.Join(tt.in[name], "|") != strings.Join(values, "|") {
+					t.Errorf("%s = %q, want %q", name, tt.in[name], values)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderLengthCap(t *testing.T) {
+	parts, err := parseValue("{path}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := render(parts, Vars{Path: strings.Repeat("a", 3*maxValueLength)}); len(got) != maxValueLength {
+		t.Errorf("rendered %d bytes, want %d", len(got), maxValueLength)
+	}
+}
+
+func TestReservedHeaders(t *testing.T) {
+	for _, name := range []string{"Host", "content-length", "Connection"} {
+		if _, err := Compile(protocol.HeaderRules{Response: []protocol.HeaderRule{{Action: "remove", Name: name}}}); err == nil {
+			t.Errorf("Compile accepted a rule for %s", name)
+		}
+	}
+}
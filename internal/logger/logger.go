@@ -1,14 +1,19 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"time"
 
-	"github.com/fatih/color"
 	"github.com/sirupsen/logrus"
 )
 
 var (
 	Logger *logrus.Logger
+
+	// logFile is the currently open log file, if any, so it can be closed on re-init
+	logFile io.Closer
 )
 
 type LogLevel string
@@ -23,43 +28,118 @@ const (
 	LevelPanic LogLevel = "panic"
 )
 
+type LogFormat string
+
+const (
+	FormatText LogFormat = "text"
+	FormatJSON LogFormat = "json"
+)
+
+// Config holds the logging settings shared by the server and client configs
+type Config struct {
+	Level    LogLevel       `mapstructure:"level"`
+	Format   LogFormat      `mapstructure:"format"`
+	File     string         `mapstructure:"file"` // empty means stdout
+	Rotation RotationConfig `mapstructure:"rotation"`
+}
+
+// RotationConfig controls when the log file is rotated, zero values disable the matching trigger
+type RotationConfig struct {
+	MaxSizeMB  int           `mapstructure:"max_size_mb"`
+	Interval   time.Duration `mapstructure:"interval"`
+	MaxBackups int           `mapstructure:"max_backups"`
+}
+
 func Init(level LogLevel, enableColors bool) {
+	if err := Setup(Config{Level: level, Format: FormatText}, enableColors); err != nil {
+		// a stdout text logger can't fail to set up, but keep the logger usable anyway
+		Logger = logrus.New()
+		Logger.Error(err)
+	}
+}
+
+// Setup (re)initializes the global logger from a Config.
+// Colors only apply to text output written to stdout.
+func Setup(cfg Config, enableColors bool) error {
+	var output io.Writer = os.Stdout
+	var file *RotatingFile
+	if cfg.File != "" {
+		var err error
+		file, err = NewRotatingFile(cfg.File, cfg.Rotation)
+		if err != nil {
+			return err
+		}
+		output = file
+		enableColors = false
+	}
+
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	if file != nil {
+		logFile = file
+	}
+
 	Logger = logrus.New()
 
-	Logger.SetOutput(os.Stdout)
+	Logger.SetOutput(output)
+	Logger.SetLevel(parseLevel(cfg.Level))
+
+	switch cfg.Format {
+	case FormatJSON:
+		Logger.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339,
+		})
+	case FormatText, "":
+		if enableColors {
+			Logger.SetFormatter(&logrus.TextFormatter{
+				ForceColors:     true,
+				FullTimestamp:   true,
+				TimestampFormat: "2006-01-02 15:04:05",
+				PadLevelText:    true,
+			})
+		} else {
+			Logger.SetFormatter(&logrus.TextFormatter{
+				DisableColors:   true,
+				FullTimestamp:   true,
+				TimestampFormat: "2006-01-02 15:04:05",
+			})
+		}
+	default:
+		return fmt.Errorf("unknown log format: %q (expected text or json)", cfg.Format)
+	}
+
+	return nil
+}
 
+// ValidateLevel reports whether the given level is one of the supported levels
+func ValidateLevel(level LogLevel) error {
+	switch level {
+	case LevelTrace, LevelDebug, LevelInfo, LevelWarn, LevelError, LevelFatal, LevelPanic, "":
+		return nil
+	}
+	return fmt.Errorf("unknown log level: %q", level)
+}
+
+func parseLevel(level LogLevel) logrus.Level {
 	switch level {
 	case LevelTrace:
-		Logger.SetLevel(logrus.TraceLevel)
+		return logrus.TraceLevel
 	case LevelDebug:
-		Logger.SetLevel(logrus.DebugLevel)
+		return logrus.DebugLevel
 	case LevelInfo:
-		Logger.SetLevel(logrus.InfoLevel)
+		return logrus.InfoLevel
 	case LevelWarn:
-		Logger.SetLevel(logrus.WarnLevel)
+		return logrus.WarnLevel
 	case LevelError:
-		Logger.SetLevel(logrus.ErrorLevel)
+		return logrus.ErrorLevel
 	case LevelFatal:
-		Logger.SetLevel(logrus.FatalLevel)
+		return logrus.FatalLevel
 	case LevelPanic:
-		Logger.SetLevel(logrus.PanicLevel)
+		return logrus.PanicLevel
 	default:
-		Logger.SetLevel(logrus.InfoLevel)
-	}
-
-	if enableColors {
-		Logger.SetFormatter(&logrus.TextFormatter{
-			ForceColors:     true,
-			FullTimestamp:   true,
-			TimestampFormat: "2006-01-02 15:04:05",
-			PadLevelText:    true,
-		})
-	} else {
-		Logger.SetFormatter(&logrus.TextFormatter{
-			DisableColors:   true,
-			FullTimestamp:   true,
-			TimestampFormat: "2006-01-02 15:04:05",
-		})
+		return logrus.InfoLevel
 	}
 }
 
@@ -134,20 +214,12 @@ func WithFields(fields logrus.Fields) *logrus.Entry {
 	return GetLogger().WithFields(fields)
 }
 
-// Critical displays a serious warning message in bold red color
+// Critical logs a serious warning, tagged with critical=true so collectors can alert on it
 func Critical(args ...interface{}) {
-	red := color.New(color.FgRed, color.Bold)
-	red.Print("⚠️  WARNING: ")
-	red.Print(args...)
-	red.Print("\n")
-	// GetLogger().Warn(args...)
+	GetLogger().WithField("critical", true).Warn(args...)
 }
 
-// Criticalf displays a serious warning message with formatting in bold red color
+// Criticalf logs a serious warning with formatting, tagged with critical=true
 func Criticalf(format string, args ...interface{}) {
-	red := color.New(color.FgRed, color.Bold)
-	red.Printf("⚠️  WARNING: ")
-	red.Printf(format, args...)
-	red.Print("\n")
-	// GetLogger().Warnf(format, args...)
+	GetLogger().WithField("critical", true).Warnf(format, args...)
 }
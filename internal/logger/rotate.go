@@ -0,0 +1,211 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp of rotated files, legacyBackupTimeFormat the one of older versions
+const (
+	backupTimeFormat       = "20060102-150405.000"
+	legacyBackupTimeFormat = "20060102-150405"
+)
+
+// RotatingFile is an io.Writer that writes to a log file and rotates it
+// once it grows past a size limit or gets older than a time interval.
+// Rotated files are renamed to <name>-<timestamp>[-<n>]<ext> next to the original,
+// n making the name unique when a file with the same timestamp already exists.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func NewRotatingFile(path string, rotation RotationConfig) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create log directory: %w", err)
+	}
+
+	rf := &RotatingFile{
+		path:       path,
+		maxSize:    int64(rotation.MaxSizeMB) * 1024 * 1024,
+		interval:   rotation.Interval,
+		maxBackups: rotation.MaxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.shouldRotate(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) shouldRotate(incoming int64) bool {
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+incoming > rf.maxSize {
+		return true
+	}
+	if rf.interval > 0 && time.Since(rf.openedAt) >= rf.interval {
+		return true
+	}
+	return false
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not stat log file: %w", err)
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = time.Now()
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	if rf.file != nil {
+		if err := rf.file.Close(); err != nil {
+			return fmt.Errorf("could not close log file: %w", err)
+		}
+		rf.file = nil
+	}
+
+	ext := filepath.Ext(rf.path)
+	base := strings.TrimSuffix(rf.path, ext)
+	stamp := time.Now().Format(backupTimeFormat)
+	backup := fmt.Sprintf("%s-%s%s", base, stamp, ext)
+	for n := 1; fileExists(backup); n++ {
+		backup = fmt.Sprintf("%s-%s-%d%s", base, stamp, n, ext)
+	}
+	if err := os.Rename(rf.path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not rotate log file: %w", err)
+	}
+
+	rf.pruneBackups()
+	return rf.open()
+}
+
+// backupFile is a file written by rotate
+type backupFile struct {
+	path string
+	at   time.Time
+	n    int
+}
+
+// pruneBackups removes the oldest rotated files so at most maxBackups are kept.
+// Only files named exactly like rotate names them are considered.
+func (rf *RotatingFile) pruneBackups() {
+	if rf.maxBackups <= 0 {
+		return
+	}
+
+	backups := rf.listBackups()
+	if len(backups) <= rf.maxBackups {
+		return
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].at.Equal(backups[j].at) {
+			return backups[i].at.Before(backups[j].at)
+		}
+		return backups[i].n < backups[j].n
+	})
+	for _, old := range backups[:len(backups)-rf.maxBackups] {
+		os.Remove(old.path)
+	}
+}
+
+func (rf *RotatingFile) listBackups() []backupFile {
+	dir := filepath.Dir(rf.path)
+	ext := filepath.Ext(rf.path)
+	prefix := strings.TrimSuffix(filepath.Base(rf.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var backups []backupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) < len(prefix)+len(ext) {
+			continue
+		}
+		if at, n, ok := parseBackupSuffix(name[len(prefix) : len(name)-len(ext)]); ok {
+			backups = append(backups, backupFile{path: filepath.Join(dir, name), at: at, n: n})
+		}
+	}
+	return backups
+}
+
+// parseBackupSuffix parses the <timestamp>[-<n>] part of a rotated file name
+func parseBackupSuffix(suffix string) (time.Time, int, bool) {
+	for _, layout := range []string{backupTimeFormat, legacyBackupTimeFormat} {
+		if len(suffix) < len(layout) {
+			continue
+		}
+		at, err := time.ParseInLocation(layout, suffix[:len(layout)], time.Local)
+		if err != nil {
+			continue
+		}
+		rest := suffix[len(layout):]
+		if rest == "" {
+			return at, 0, true
+		}
+		if !strings.HasPrefix(rest, "-") {
+			continue
+		}
+		n, err := strconv.Atoi(rest[1:])
+		if err != nil || n <= 0 || strconv.Itoa(n) != rest[1:] {
+			continue
+		}
+		return at, n, true
+	}
+	return time.Time{}, 0, false
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
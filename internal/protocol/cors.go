@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	CORSModePassthrough = "passthrough" // the local app handles CORS, preflight requests are forwarded (default)
+	CORSModeOff         = "off"         // cross-origin requests are refused, CORS headers of the app are dropped
+	CORSModePolicy      = "policy"      // the server answers preflight requests and sets the CORS headers
+)
+
+// CORSPolicy is how the server handles the cross-origin requests of a tunnel
+type CORSPolicy struct {
+	Mode             string   `json:"mode"`
+	AllowOrigins     []string `json:"allow_origins,omitempty"` // "*", full origins or wildcards like https://*.example.com
+	AllowMethods     []string `json:"allow_methods,omitempty"` // defaults to GET, HEAD, POST, PUT, PATCH and DELETE
+	AllowHeaders     []string `json:"allow_headers,omitempty"` // empty or "*" allows the headers a preflight asks for
+	ExposeHeaders    []string `json:"expose_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	MaxAgeSeconds    int      `json:"max_age_seconds,omitempty"` // how long browsers may cache a preflight answer
+}
+
+// ValidateCORS checks the mode and, for the policy mode, the allowed origins
+func ValidateCORS(policy CORSPolicy) error {
+	switch policy.Mode {
+	case "", CORSModePassthrough, CORSModeOff:
+		return nil
+	case CORSModePolicy:
+	default:
+		return fmt.Errorf("unknown CORS mode %q, use %s, %s or %s", policy.Mode, CORSModePassthrough, CORSModeOff, CORSModePolicy)
+	}
+
+	if len(policy.AllowOrigins) == 0 {
+		return fmt.Errorf("the %s CORS mode needs at least one allowed origin", CORSModePolicy)
+	}
+	for _, origin := range policy.AllowOrigins {
+		if origin == "*" {
+			if policy.AllowCredentials {
+				return fmt.Errorf(`the "*" origin cannot be used with credentials, list the origins instead`)
+			}
+			continue
+		}
+		if err := validateOrigin(origin); err != nil {
+			return err
+		}
+	}
+	if policy.MaxAgeSeconds < 0 {
+		return fmt.Errorf("CORS max age cannot be negative")
+	}
+	return nil
+}
+
+func validateOrigin(origin string) error {
+	u, err := url.Parse(strings.Replace(origin, "*.", "wildcard.", 1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return fmt.Errorf("invalid CORS origin %q, expected scheme://host[:port] like https://app.example.com", origin)
+	}
+	if strings.Contains(origin, "*") && !strings.HasPrefix(origin, u.Scheme+"://*.") {
+		return fmt.Errorf("invalid CORS origin %q, a wildcard is only allowed as the first label, like https://*.example.com", origin)
+	}
+	return nil
+}
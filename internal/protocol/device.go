@@ -0,0 +1,39 @@
+package protocol
+
+// Endpoints of the device-authorization flow used by gtc login (RFC 8628)
+const (
+	DeviceCodePath   = "/___gTl___/device/code"
+	DeviceTokenPath  = "/___gTl___/device/token"
+	DeviceVerifyPath = "/___gTl___/device"
+)
+
+// Errors returned while polling DeviceTokenPath
+const (
+	DeviceErrorPending  = "authorization_pending"
+	DeviceErrorSlowDown = "slow_down" // the client polls faster than the interval, it must add 5 seconds to it
+	DeviceErrorExpired  = "expired_token"
+	DeviceErrorInvalid  = "invalid_grant"
+)
+
+type DeviceCodeRequest struct {
+	Name string `json:"name"` // shown to the admin approving the device, e.g. the hostname
+}
+
+type DeviceCodeResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"` // seconds
+	Interval        int    `json:"interval"`   // seconds to wait between polls
+}
+
+type DeviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+type DeviceTokenResponse struct {
+	AccessToken      string `json:"access_token,omitempty"`
+	DeviceID         string `json:"device_id,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
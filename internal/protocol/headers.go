@@ -0,0 +1,26 @@
+package protocol
+
+const (
+	HeaderActionAdd    = "add"    // add a value, keeping the ones already there
+	HeaderActionSet    = "set"    // replace all the values
+	HeaderActionRemove = "remove" // drop the header
+)
+
+// HeaderRule changes one header of the requests or responses of a tunnel.
+// Value can use placeholders like {client_ip}, see the headers package for the list.
+type HeaderRule struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"`
+}
+
+// HeaderRules are applied in order to requests before they reach the local app
+// and to responses before they are returned to the public client
+type HeaderRules struct {
+	Request  []HeaderRule `json:"request,omitempty"`
+	Response []HeaderRule `json:"response,omitempty"`
+}
+
+func (r HeaderRules) Empty() bool {
+	return len(r.Request) == 0 && len(r.Response) == 0
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	PathModeStrip    = "strip"    // remove the tunnel prefix, /app-1/x reaches the local app as /x (default)
+	PathModePreserve = "preserve" // keep the prefix, /app-1/x reaches the local app as /app-1/x
+	PathModeRewrite  = "rewrite"  // remove the prefix, then apply the first matching rewrite rule
+)
+
+// PathRewrite replaces a path matching a regular expression, Replace can use $1 or ${name}
+type PathRewrite struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+}
+
+// PathOptions control the path the local app sees and how the paths it answers with are fixed up
+type PathOptions struct {
+	Mode     string        `json:"mode,omitempty"`
+	Rewrites []PathRewrite `json:"rewrites,omitempty"`
+
+	RewriteLocation   bool `json:"rewrite_location,omitempty"`    // add the prefix to redirects of the local app
+	RewriteCookiePath bool `json:"rewrite_cookie_path,omitempty"` // add the prefix to the Path of its cookies
+
+	// UpstreamHost is the host:port of the local app, its absolute redirects are moved to the public URL
+	UpstreamHost string `json:"upstream_host,omitempty"`
+}
+
+// ValidatePathOptions checks the mode and the regular expressions of the rewrite rules
+func ValidatePathOptions(options PathOptions) error {
+	switch options.Mode {
+	case "", PathModeStrip, PathModePreserve:
+		if len(options.Rewrites) > 0 {
+			return fmt.Errorf("path rewrite rules need the %s path mode", PathModeRewrite)
+		}
+	case PathModeRewrite:
+		if len(options.Rewrites) == 0 {
+			return fmt.Errorf("the %s path mode needs at least one rewrite rule", PathModeRewrite)
+		}
+	default:
+		return fmt.Errorf("unknown path mode %q, use %s, %s or %s", options.Mode, PathModeStrip, PathModePreserve, PathModeRewrite)
+	}
+
+	for _, rewrite := range options.Rewrites {
+		if _, err := regexp.Compile(rewrite.Match); err != nil {
+			return fmt.Errorf("invalid path rewrite %q: %w", rewrite.Match, err)
+		}
+	}
+	return nil
+}
@@ -2,8 +2,16 @@ package protocol
 
 import (
 	"encoding/json"
+	"net/http"
 )
 
+// Version is the protocol spoken by this build. Version 2 tags every message of a request with
+// its request ID so responses can arrive in any order; version 1 peers (which sent no version)
+// matched responses to requests by arrival order. Version 3 sends headers as lists of values,
+// every value of repeated headers, where earlier versions sent one string per header.
+// Peers with an older version are refused at the handshake.
+const Version = 3
+
 type MessageType int
 
 const (
@@ -16,40 +24,101 @@ const (
 	MessaageTypeConfigRequest MessageType = 5
 	MessageTypeConfigResponse MessageType = 6
 	MessageTypeError          MessageType = 7
+
+	MessageTypeUsage MessageType = 8 // server to client, see UsageReport
 )
 
 type SocketMessage struct {
-	// ID      string            `json:"ID"` // ? to add : connection id
+	ID      string          `json:"id,omitempty"` // request ID, used to match responses to requests
 	Type    MessageType     `json:"type"`
 	Payload json.RawMessage `json:"payload"`
 }
 
 type HTTPRequestMessage struct {
-	Method  string            `json:"method"`
-	URL     string            `json:"url"`
-	Headers map[string]string `json:"headers"`
-	Body    []byte            `json:"body"`
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Host    string      `json:"host,omitempty"` // Host of the public request
+	Headers http.Header `json:"headers"`
+	Body    []byte      `json:"body"`
 }
 
 type HTTPResponseMessage struct {
-	StatusCode int               `json:"status_code"`
-	Headers    map[string]string `json:"headers"`
-	Body       []byte            `json:"body"`
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers"` // every value, e.g. one per Set-Cookie
+	Body       []byte      `json:"body"`
+}
+
+type ErrorCode string
+
+const (
+	ErrorCodeConnectionRefused ErrorCode = "connection_refused"
+	ErrorCodeDNSFailure        ErrorCode = "dns_failure"
+	ErrorCodeUpstreamTimeout   ErrorCode = "upstream_timeout"
+	ErrorCodeUpstreamTLS       ErrorCode = "upstream_tls_error"
+	ErrorCodeUpstreamError     ErrorCode = "upstream_error"
+	ErrorCodeInvalidRequest    ErrorCode = "invalid_request"
+	ErrorCodeResponseTooLarge  ErrorCode = "response_too_large"
+)
+
+// ErrorMessage is sent instead of an HTTPResponseMessage when the client could not get a response
+// from the local service, so the server can answer the public request right away
+type ErrorMessage struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
 }
 
 type AuthRequestMessage struct {
-	AccessToken string `json:"access_token"`
-	BaseURL     string `json:"base_url"`
+	ProtocolVersion   int    `json:"protocol_version,omitempty"` // see Version, 0 for version 1 clients
+	AccessToken       string `json:"access_token"`
+	BaseURL           string `json:"base_url"`
+	ResponseTimeoutMs int64  `json:"response_timeout_ms,omitempty"` // per-tunnel override, 0 uses the server default
+
+	DisableForwardedHeaders bool `json:"disable_forwarded_headers,omitempty"`
+	RecordHAR               bool `json:"record_har,omitempty"` // ask the server to record the tunnel's traffic as HAR
+
+	BasicAuth []BasicAuthCredential `json:"basic_auth,omitempty"` // protect the public URL, the server keeps only hashes
+
+	AllowCIDRs []string `json:"allow_cidrs,omitempty"` // only these networks may reach the public URL
+	DenyCIDRs  []string `json:"deny_cidrs,omitempty"`
+
+	OIDC *OIDCRequirement `json:"oidc,omitempty"` // visitors must sign in with the server's OIDC provider
+
+	RateLimit *RateLimit `json:"rate_limit,omitempty"` // limit on the public requests of the tunnel
+
+	CORS *CORSPolicy `json:"cors,omitempty"` // replaces the server's default CORS handling
+
+	Headers *HeaderRules `json:"headers,omitempty"` // rewrite the tunnel's request and response headers
+
+	Paths *PathOptions `json:"paths,omitempty"` // path sent to the local app, the prefix is stripped by default
+}
+
+// OIDCRequirement restricts the visitors signed in with OIDC, empty lists accept anyone signed in
+type OIDCRequirement struct {
+	EmailDomains []string `json:"email_domains,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+}
+
+type BasicAuthCredential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 type AuthResponseMessage struct {
-	ID      *string `json:"id,omitempty"`
-	Success bool    `json:"success,omitempty"`
-	Message string  `json:"error,omitempty"` // Optional error message if success is false
-	BaseURL string  `json:"base_url"`
+	ProtocolVersion   int     `json:"protocol_version,omitempty"` // see Version, 0 for version 1 servers
+	ID                *string `json:"id,omitempty"`
+	Success           bool    `json:"success,omitempty"`
+	Message           string  `json:"error,omitempty"` // Optional error message if success is false
+	BaseURL           string  `json:"base_url"`
+	ResponseTimeoutMs int64   `json:"response_timeout_ms,omitempty"` // timeout the server applies to this tunnel
+	RecordingHAR      bool    `json:"recording_har,omitempty"`       // the server records the tunnel's traffic as HAR
+
+	// size limits the server enforces for this tunnel, in bytes
+	MaxRequestBody  int64 `json:"max_request_body,omitempty"`
+	MaxResponseBody int64 `json:"max_response_body,omitempty"`
+	MaxMessageSize  int64 `json:"max_message_size,omitempty"`
 }
 
-func NewHTTPRequestMessage(id, method, url string, headers map[string]string, body []byte) (*SocketMessage, error) {
+func NewHTTPRequestMessage(id, method, url string, headers http.Header, body []byte) (*SocketMessage, error) {
 	httpReq := HTTPRequestMessage{
 		Method:  method,
 		URL:     url,
@@ -60,7 +129,7 @@ func NewHTTPRequestMessage(id, method, url string, headers map[string]string, bo
 	return NewSocketMessage(id, MessageTypeHTTPRequest, httpReq)
 }
 
-func NewHTTPResponseMessage(id string, statusCode int, headers map[string]string, body []byte) (*SocketMessage, error) {
+func NewHTTPResponseMessage(id string, statusCode int, headers http.Header, body []byte) (*SocketMessage, error) {
 	httpResp := HTTPResponseMessage{
 		StatusCode: statusCode,
 		Headers:    headers,
@@ -70,6 +139,15 @@ func NewHTTPResponseMessage(id string, statusCode int, headers map[string]string
 	return NewSocketMessage(id, MessageTypeHTTPResponse, httpResp)
 }
 
+func NewErrorMessage(id string, code ErrorCode, message string) (*SocketMessage, error) {
+	errMsg := ErrorMessage{
+		Code:    code,
+		Message: message,
+	}
+
+	return NewSocketMessage(id, MessageTypeError, errMsg)
+}
+
 func NewSocketMessage(id string, msgType MessageType, payload interface{}) (*SocketMessage, error) {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -77,7 +155,7 @@ func NewSocketMessage(id string, msgType MessageType, payload interface{}) (*Soc
 	}
 
 	return &SocketMessage{
-		// ID:      id,
+		ID:      id,
 		Type:    msgType,
 		Payload: payloadBytes,
 	}, nil
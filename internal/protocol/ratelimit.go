@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit is a token-bucket limit on the public requests of a tunnel
+type RateLimit struct {
+	Rate  string `json:"rate"`            // see ParseRate
+	Burst int    `json:"burst,omitempty"` // requests allowed at once, defaults to the count of Rate
+}
+
+// ParseRate parses a rate written as "<count>/<period>", where period is s, m, h or a
+// duration like 30s. An empty rate or "off" returns a zero count, meaning no limit.
+func ParseRate(rate string) (int, time.Duration, error) {
+	rate = strings.TrimSpace(rate)
+	if rate == "" || rate == "off" {
+		return 0, 0, nil
+	}
+
+	countText, periodText, ok := strings.Cut(rate, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("rate %q must be in the form <count>/<period>, e.g. 100/m", rate)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(countText))
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("rate %q must start with a positive count", rate)
+	}
+
+	periodText = strings.TrimSpace(periodText)
+	var period time.Duration
+	switch periodText {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		period, err = time.ParseDuration(periodText)
+		if err != nil || period <= 0 {
+			return 0, 0, fmt.Errorf("rate %q has an invalid period, use s, m, h or a duration", rate)
+		}
+	}
+	return count, period, nil
+}
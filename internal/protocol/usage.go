@@ -0,0 +1,18 @@
+package protocol
+
+import "time"
+
+// UsageReport tells a client how much of the quotas of its token and tunnel is used.
+// It is sent after authentication and whenever a quota crosses 80% or 100%.
+type UsageReport struct {
+	Quotas []QuotaStatus `json:"quotas"`
+}
+
+type QuotaStatus struct {
+	Scope    string    `json:"scope"` // "token" or "tunnel"
+	Name     string    `json:"name"`  // daily_bytes, monthly_bytes, daily_requests or monthly_requests
+	Used     int64     `json:"used"`
+	Limit    int64     `json:"limit"`
+	ResetsAt time.Time `json:"resets_at"`
+	Throttle int64     `json:"throttle,omitempty"` // bytes per second once used up, 0 when requests are rejected
+}
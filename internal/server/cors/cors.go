@@ -0,0 +1,171 @@
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+var defaultMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// Policy is the CORS handling of a tunnel. A nil policy leaves CORS to the local app.
+type Policy struct {
+	Mode string
+
+	anyOrigin   bool
+	origins     map[string]bool
+	wildcards   [][2]string // scheme:// and .domain of origins like https://*.example.com
+	methods     string
+	headers     string // empty reflects the headers a preflight asks for
+	expose      string
+	credentials bool
+	maxAge      string
+}
+
+// New checks a policy and prepares it for the requests of a tunnel
+func New(p protocol.CORSPolicy) (*Policy, error) {
+	if err := protocol.ValidateCORS(p); err != nil {
+		return nil, err
+	}
+	policy := &Policy{Mode: p.Mode, origins: make(map[string]bool)}
+	if policy.Mode == "" {
+		policy.Mode = protocol.CORSModePassthrough
+	}
+	if policy.Mode != protocol.CORSModePolicy {
+		return policy, nil
+	}
+
+	for _, origin := range p.AllowOrigins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		switch {
+		case origin == "*":
+			policy.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			scheme, domain, _ := strings.Cut(origin, "*")
+			policy.wildcards = append(policy.wildcards, [2]string{scheme, domain})
+		default:
+			policy.origins[origin] = true
+		}
+	}
+
+	methods := p.AllowMethods
+	if len(methods) == 0 {
+		methods = defaultMethods
+	}
+	policy.methods = strings.ToUpper(strings.Join(methods, ", "))
+	if !(len(p.AllowHeaders) == 1 && p.AllowHeaders[0] == "*") {
+		policy.headers = strings.Join(p.AllowHeaders, ", ")
+	}
+	policy.expose = strings.Join(p.ExposeHeaders, ", ")
+	policy.credentials = p.AllowCredentials
+	if p.MaxAgeSeconds > 0 {
+		policy.maxAge = strconv.Itoa(p.MaxAgeSeconds)
+	}
+	return policy, nil
+}
+
+// Passthrough reports whether requests and responses are left as they are for the local app
+func (p *Policy) Passthrough() bool {
+	return p == nil || p.Mode == protocol.CORSModePassthrough
+}
+
+// IsPreflight reports whether r is a browser asking if a cross-origin request is allowed
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// AllowsOrigin reports whether the policy lets pages from origin read the responses
+func (p *Policy) AllowsOrigin(origin string) bool {
+	if p.Passthrough() || p.Mode != protocol.CORSModePolicy || origin == "" {
+		return false
+	}
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) && len(origin) > len(w[0])+len(w[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Preflight sets the headers answering a preflight request, it returns false when the
+// origin or the method is not allowed
+func (p *Policy) Preflight(h http.Header, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if !p.AllowsOrigin(origin) || !p.allowsMethod(r.Header.Get("Access-Control-Request-Method")) {
+		return false
+	}
+	p.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.methods)
+	if headers := p.headers; headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+		addVary(h, "Access-Control-Request-Headers")
+	}
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	return true
+}
+
+// Apply replaces the CORS headers of a response with the ones of the policy. It does
+// nothing in passthrough mode, and only drops the headers of the app in off mode.
+func (p *Policy) Apply(h http.Header, origin string) {
+	if p.Passthrough() {
+		return
+	}
+	for name := range h {
+		if strings.HasPrefix(name, "Access-Control-") {
+			h.Del(name)
+		}
+	}
+	if !p.AllowsOrigin(origin) {
+		return
+	}
+	p.setOrigin(h, origin)
+	if p.expose != "" {
+		h.Set("Access-Control-Expose-Headers", p.expose)
+	}
+}
+
+func (p *Policy) setOrigin(h http.Header, origin string) {
+	if p.anyOrigin && !p.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	addVary(h, "Origin")
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p *Policy) allowsMethod(method string) bool {
+	for _, allowed := range strings.Split(p.methods, ", ") {
+		if allowed == method {
+			return true
+		}
+	}
+	// simple methods never need a preflight, but browsers may still send one
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodPost
+}
+
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
@@ -0,0 +1,200 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  protocol.CORSPolicy
+		wantErr bool
+	}{
+		{"default is passthrough", protocol.CORSPolicy{}, false},
+		{"off", protocol.CORSPolicy{Mode: protocol.CORSModeOff}, false},
+		{"policy", protocol.CORSPolicy{Mode: protocol.CORSModePolicy, AllowOrigins: []string{"https://app.example.com"}}, false},
+		{"policy without origins", protocol.CORSPolicy{Mode: protocol.CORSModePolicy}, true},
+		{"unknown mode", protocol.CORSPolicy{Mode: "allow-all"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAllowsOrigin(t *testing.T) {
+	policy, err := New(protocol.CORSPolicy{
+		Mode:         protocol.CORSModePolicy,
+		AllowOrigins: []string{"https://app.example.com/", "https://*.preview.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passthrough, err := New(protocol.CORSPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		policy *Policy
+		origin string
+		want   bool
+	}{
+		{"exact", policy, "https://app.example.com", true},
+		{"case insensitive", policy, "https://APP.example.com", true},
+		{"other scheme", policy, "http://app.example.com", false},
+		{"wildcard subdomain", policy, "https://pr-1.preview.example.com", true},
+		{"wildcard needs a subdomain", policy, "https://.preview.example.com", false},
+		{"wildcard suffix only", policy, "https://evilpreview.example.com", false},
+		{"unknown", policy, "https://evil.example", false},
+		{"empty", policy, "", false},
+		{"passthrough", passthrough, "https://app.example.com", false},
+		{"nil", nil, "https://app.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.AllowsOrigin(tt.origin); got != tt.want {
+				t.Errorf("AllowsOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  protocol.CORSPolicy
+		origin  string
+		method  string
+		headers string
+		wantOK  bool
+		want    map[string]string
+	}{
+		{
+			name:    "allowed origin reflects the requested headers",
+			policy:  protocol.CORSPolicy{Mode: protocol.CORSModePolicy, AllowOrigins: []string{"https://app.example.com"}, MaxAgeSeconds: 600},
+			origin:  "https://app.example.com",
+			method:  "PUT",
+			headers: "X-Token",
+			wantOK:  true,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, HEAD, POST, PUT, PATCH, DELETE",
+				"Access-Control-Allow-Headers": "X-Token",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:   "any origin without credentials",
+			policy: protocol.CORSPolicy{Mode: protocol.CORSModePolicy, AllowOrigins: []string{"*"}, AllowHeaders: []string{"Content-Type"}},
+			origin: "https://anything.example",
+			method: "DELETE",
+			wantOK: true,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Headers": "Content-Type",
+			},
+		},
+		{
+			name:    "credentials echo the origin",
+			policy:  protocol.CORSPolicy{Mode: protocol.CORSModePolicy, AllowOrigins: []string{"https://*.example.com"}, AllowCredentials: true},
+			origin:  "https://pr-1.example.com",
+			method:  "GET",
+			headers: "X-Token",
+			wantOK:  true,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://pr-1.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Vary":                             "Origin, Access-Control-Request-Headers",
+			},
+		},
+		{
+			name:   "method not allowed",
+			policy: protocol.CORSPolicy{Mode: protocol.CORSModePolicy, AllowOrigins: []string{"*"}, AllowMethods: []string{"GET"}},
+			origin: "https://anything.example",
+			method: "DELETE",
+		},
+		{
+			name:   "simple method always allowed",
+			policy: protocol.CORSPolicy{Mode: protocol.CORSModePolicy, AllowOrigins: []string{"*"}, AllowMethods: []string{"PUT"}},
+			origin: "https://anything.example",
+			method: "POST",
+			wantOK: true,
+		},
+		{
+			name:   "origin not allowed",
+			policy: protocol.CORSPolicy{Mode: protocol.CORSModePolicy, AllowOrigins: []string{"https://app.example.com"}},
+			origin: "https://evil.example",
+			method: "GET",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := New(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodOptions, "/", nil)
+			r.Header.Set("Origin", tt.origin)
+			r.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				r.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			if !IsPreflight(r) {
+				t.Fatal("IsPreflight() = false")
+			}
+
+			h := http.Header{}
+			if ok := policy.Preflight(h, r); ok != tt.wantOK {
+				t.Fatalf("Preflight() = %v, want %v", ok, tt.wantOK)
+			}
+			for name, want := range tt.want {
+				if got := h.Values(name); strings.Join(got, ", ") != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	appHeaders := func() http.Header {
+		return http.Header{"Access-Control-Allow-Origin": {"*"}, "Content-Type": {"text/plain"}}
+	}
+	tests := []struct {
+		name       string
+		policy     protocol.CORSPolicy
+		origin     string
+		wantOrigin string
+	}{
+		{"passthrough keeps the app headers", protocol.CORSPolicy{}, "https://evil.example", "*"},
+		{"off drops the app headers", protocol.CORSPolicy{Mode: protocol.CORSModeOff}, "https://app.example.com", ""},
+		{"policy replaces them", protocol.CORSPolicy{Mode: protocol.CORSModePolicy, AllowOrigins: []string{"https://app.example.com"}}, "https://app.example.com", "https://app.example.com"},
+		{"policy drops them for other origins", protocol.CORSPolicy{Mode: protocol.CORSModePolicy, AllowOrigins: []string{"https://app.example.com"}}, "https://evil.example", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := New(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := appHeaders()
+			policy.Apply(h, tt.origin)
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if h.Get("Content-Type") != "text/plain" {
+				t.Error("Apply removed a non-CORS header")
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package errorpages
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+// Error codes for failures detected by the server itself, the client reports the upstream codes
+// defined in the protocol package (connection_refused, dns_failure, ...)
+const (
+	CodeTunnelOffline      = "tunnel_offline"
+	CodeTunnelTimeout      = "tunnel_timeout"
+	CodeTunnelWriteFailed  = "tunnel_write_failed"
+	CodeInvalidTunnelReply = "invalid_tunnel_response"
+	CodeInternalError      = "internal_error"
+	CodeAuthRequired       = "auth_required"
+	CodeIPNotAllowed       = "ip_not_allowed"
+	CodeLoginRequired      = "login_required"
+	CodeLoginFailed        = "login_failed"
+	CodeIdentityNotAllowed = "identity_not_allowed"
+	CodeRateLimited        = "rate_limited"
+	CodeRequestTooLarge    = "request_too_large"
+	CodeQuotaExceeded      = "quota_exceeded"
+	CodeCORSRejected       = "cors_rejected"
+)
+
+const defaultTemplateName = "error"
+
+// jsonFuncs are the functions of JSON templates, {{json .Detail}} writes a value as a JSON literal
+var jsonFuncs = texttemplate.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+//go:embed templates/*
+var defaultTemplates embed.FS
+
+// Page holds everything an error template can render
+type Page struct {
+	Status     int       `json:"status"`
+	StatusText string    `json:"error"`
+	Code       string    `json:"code"`
+	Title      string    `json:"title"`
+	Summary    string    `json:"summary"`
+	Detail     string    `json:"detail,omitempty"`
+	Source     string    `json:"source"` // "tunnel" when the tunnel is at fault, "app" when the local service is, "access" when the request is not allowed
+	RequestID  string    `json:"request_id,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Renderer writes error pages, preferring templates from a custom directory over the built-in ones.
+// Custom templates are looked up as <code>.html, <status>.html and error.html (and the same with
+// .json for JSON responses).
+type Renderer struct {
+	html map[string]*htmltemplate.Template
+	json map[string]*texttemplate.Template
+}
+
+// New loads the built-in templates and, if dir is not empty, the custom ones found in dir
+func New(dir string) (*Renderer, error) {
+	r := &Renderer{
+		html: make(map[string]*htmltemplate.Template),
+		json: make(map[string]*texttemplate.Template),
+	}
+
+	defaultHTML, err := htmltemplate.ParseFS(defaultTemplates, "templates/error.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse built-in error template: %w", err)
+	}
+	r.html[defaultTemplateName] = defaultHTML
+
+	if dir == "" {
+		return r, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error pages directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+
+		switch filepath.Ext(entry.Name()) {
+		case ".html":
+			tmpl, err := htmltemplate.ParseFiles(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse error template %s: %w", entry.Name(), err)
+			}
+			r.html[name] = tmpl
+		case ".json":
+			tmpl, err := texttemplate.New(entry.Name()).Funcs(jsonFuncs).ParseFiles(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse error template %s: %w", entry.Name(), err)
+			}
+			r.json[name] = tmpl
+		}
+	}
+
+	return r, nil
+}
+
+// NewPage builds a page for the given status and code, filling in a title and summary
+func NewPage(status int, code, detail, requestID string) Page {
+	title, summary, source := describe(code)
+	return Page{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Code:       code,
+		Title:      title,
+		Summary:    summary,
+		Detail:     detail,
+		Source:     source,
+		RequestID:  requestID,
+		Time:       time.Now().UTC(),
+	}
+}
+
+// Write renders the page as JSON or HTML depending on the Accept header of the request
+func (rd *Renderer) Write(w http.ResponseWriter, r *http.Request, page Page) {
+	if page.RequestID != "" {
+		w.Header().Set("X-Request-Id", page.RequestID)
+	}
+	w.Header().Set("X-GTunnel-Error", page.Code)
+	w.Header().Set("Cache-Control", "no-store")
+
+	var buf bytes.Buffer
+	contentType := "text/html; charset=utf-8"
+	if wantsJSON(r) {
+		contentType = "application/json"
+		if err := rd.renderJSON(&buf, page); err != nil {
+			buf.Reset()
+			json.NewEncoder(&buf).Encode(page)
+		}
+	} else if err := rd.renderHTML(&buf, page); err != nil {
+		buf.Reset()
+		contentType = "text/plain; charset=utf-8"
+		fmt.Fprintf(&buf, "gTunnel: %d %s\n%s\n", page.Status, page.StatusText, page.Summary)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(page.Status)
+	w.Write(buf.Bytes())
+}
+
+// Error is a shortcut for NewPage followed by Write
+func (rd *Renderer) Error(w http.ResponseWriter, r *http.Request, status int, code, detail, requestID string) {
+	rd.Write(w, r, NewPage(status, code, detail, requestID))
+}
+
+func (rd *Renderer) renderHTML(w io.Writer, page Page) error {
+	for _, name := range templateNames(page) {
+		if tmpl, ok := rd.html[name]; ok {
+			return tmpl.Execute(w, page)
+		}
+	}
+	return fmt.Errorf("no html error template found")
+}
+
+// renderJSON fails when a template does not render valid JSON, e.g. a value with quotes
+// written without the json function, so the caller can fall back to the plain page
+func (rd *Renderer) renderJSON(w io.Writer, page Page) error {
+	for _, name := range templateNames(page) {
+		if tmpl, ok := rd.json[name]; ok {
+			var out bytes.Buffer
+			if err := tmpl.Execute(&out, page); err != nil {
+				return err
+			}
+			if !json.Valid(out.Bytes()) {
+				return fmt.Errorf("error template %s.json did not render valid JSON", name)
+			}
+			_, err := w.Write(out.Bytes())
+			return err
+		}
+	}
+	return json.NewEncoder(w).Encode(page)
+}
+
+// templateNames lists the template names to try, most specific first
+func templateNames(page Page) []string {
+	return []string{page.Code, strconv.Itoa(page.Status), defaultTemplateName}
+}
+
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") {
+		return !strings.Contains(accept, "text/html")
+	}
+	return false
+}
+
+// StatusForUpstreamCode maps an error code reported by the client to the HTTP status returned publicly
+func StatusForUpstreamCode(code protocol.ErrorCode) int {
+	if code == protocol.ErrorCodeUpstreamTimeout {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// describe returns the title, summary and the side at fault for an error code
+func describe(code string) (string, string, string) {
+	switch code {
+	case CodeTunnelOffline:
+		return "Tunnel offline",
+			"No gTunnel client is connected for this URL. The machine running the tunnel may be offline or the tunnel was stopped.",
+			"tunnel"
+	case CodeTunnelTimeout:
+		return "Tunnel did not respond",
+			"The gTunnel client is connected but did not answer in time. The tunnel connection may be slow or stuck.",
+			"tunnel"
+	case CodeTunnelWriteFailed:
+		return "Tunnel connection lost",
+			"The request could not be sent to the gTunnel client. The tunnel connection was probably interrupted.",
+			"tunnel"
+	case CodeInvalidTunnelReply:
+		return "Invalid tunnel response",
+			"The gTunnel client sent a response the server could not understand. Client and server versions may not match.",
+			"tunnel"
+	case CodeAuthRequired:
+		return "Authentication required",
+			"This tunnel is protected. Sign in with the username and password given by its owner.",
+			"access"
+	case CodeIPNotAllowed:
+		return "Access denied",
+			"This tunnel only accepts requests from some networks, and your IP address is not one of them.",
+			"access"
+	case CodeLoginRequired:
+		return "Sign-in required",
+			"This tunnel only accepts signed-in visitors. Open its URL in a browser to sign in.",
+			"access"
+	case CodeLoginFailed:
+		return "Sign-in failed",
+			"The sign-in with the identity provider could not be completed.",
+			"access"
+	case CodeIdentityNotAllowed:
+		return "Access denied",
+			"You are signed in, but the owner of this tunnel did not allow your account.",
+			"access"
+	case CodeRateLimited:
+		return "Too many requests",
+			"This tunnel or your IP address sent too many requests. Wait a moment and try again.",
+			"access"
+	case CodeQuotaExceeded:
+		return "Quota used up",
+			"This tunnel used up its traffic quota. It will accept requests again when the quota resets.",
+			"access"
+	case CodeCORSRejected:
+		return "Cross-origin request refused",
+			"This tunnel does not accept cross-origin requests from the page that sent this one.",
+			"access"
+	case CodeRequestTooLarge:
+		return "Request too large",
+			"The request body is larger than this tunnel accepts.",
+			"access"
+	case string(protocol.ErrorCodeResponseTooLarge):
+		return "Response too large",
+			"The tunnel is online, but the local service answered with a body larger than this tunnel allows.",
+			"app"
+	case string(protocol.ErrorCodeConnectionRefused):
+		return "Local app is not running",
+			"The tunnel is online, but the local service refused the connection. Check that the app is running on the tunneled port.",
+			"app"
+	case string(protocol.ErrorCodeDNSFailure):
+		return "Local host not found",
+			"The tunnel is online, but the host of the local service could not be resolved.",
+			"app"
+	case string(protocol.ErrorCodeUpstreamTimeout):
+		return "Local app timed out",
+			"The tunnel is online, but the local service did not respond in time.",
+			"app"
+	case string(protocol.ErrorCodeUpstreamTLS):
+		return "Local app TLS error",
+			"The tunnel is online, but the TLS connection to the local service failed. The client may need to trust its certificate.",
+			"app"
+	case string(protocol.ErrorCodeUpstreamError):
+		return "Local app failed",
+			"The tunnel is online, but the local service failed to respond.",
+			"app"
+	case string(protocol.ErrorCodeInvalidRequest):
+		return "Request could not be forwarded",
+			"The tunnel client could not build the request for the local service.",
+			"tunnel"
+	default:
+		return "Something went wrong",
+			"The gTunnel server could not process this request.",
+			"server"
+	}
+}
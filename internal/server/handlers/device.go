@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/repositories"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/utils"
+)
+
+//go:embed templates/device.html
+var deviceTemplates embed.FS
+
+var devicePage = template.Must(template.ParseFS(deviceTemplates, "templates/device.html"))
+
+const (
+	// maxDeviceRequestSize bounds the JSON and form bodies of the device flow endpoints
+	maxDeviceRequestSize = 16 << 10
+	// pollTolerance is how much earlier than the interval a poll may come before it is answered
+	// with slow_down, requests do not always take the same time to arrive
+	pollTolerance = time.Second
+)
+
+// DeviceHandlers serves the device-authorization flow used by gtc login
+type DeviceHandlers struct {
+	Devices        repositories.DeviceRepository
+	TrustedProxies []*net.IPNet
+
+	pollsMu sync.Mutex
+	polls   map[string]time.Time // device code of a pending login -> when it was last polled
+}
+
+type devicePageData struct {
+	UserCode string
+	Approved string
+	Error    string
+}
+
+// Code starts a login and returns the codes the client shows to the user
+func (h *DeviceHandlers) Code(w http.ResponseWriter, r *http.Request) {
+	var req protocol.DeviceCodeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDeviceRequestSize)).Decode(&req); err != nil {
+		writeDeviceJSON(w, http.StatusBadRequest, protocol.DeviceTokenResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "unnamed device"
+	}
+	remoteAddr := utils.ClientIP(r, h.TrustedProxies)
+
+	auth, err := h.Devices.CreateAuthorization(name, remoteAddr)
+	if err != nil {
+		logger.Errorf("Failed to start device login: %v", err)
+		writeDeviceJSON(w, http.StatusServiceUnavailable, protocol.DeviceTokenResponse{Error: "temporarily_unavailable", ErrorDescription: err.Error()})
+		return
+	}
+	logger.WithField("remote_addr", remoteAddr).Infof("Device login started for %q, code %s", name, auth.UserCode)
+
+	writeDeviceJSON(w, http.StatusOK, protocol.DeviceCodeResponse{
+		DeviceCode:      auth.DeviceCode,
+		UserCode:        auth.UserCode,
+		VerificationURI: utils.RequestScheme(r, h.TrustedProxies) + "://" + r.Host + protocol.DeviceVerifyPath,
+		ExpiresIn:       int(models.DeviceCodeTTL.Seconds()),
+		Interval:        int(models.DevicePollInterval.Seconds()),
+	})
+}
+
+// Token is polled by the client until its login is approved, then returns the device token
+func (h *DeviceHandlers) Token(w http.ResponseWriter, r *http.Request) {
+	var req protocol.DeviceTokenRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDeviceRequestSize)).Decode(&req); err != nil || req.DeviceCode == "" {
+		writeDeviceJSON(w, http.StatusBadRequest, protocol.DeviceTokenResponse{Error: "invalid_request", ErrorDescription: "device_code is required"})
+		return
+	}
+
+	if h.pollTooSoon(req.DeviceCode) {
+		writeDeviceJSON(w, http.StatusBadRequest, protocol.DeviceTokenResponse{Error: protocol.DeviceErrorSlowDown, ErrorDescription: "polling faster than the interval"})
+		return
+	}
+
+	token, device, err := h.Devices.IssueToken(req.DeviceCode)
+	h.recordPoll(req.DeviceCode, errors.Is(err, repositories.ErrAuthorizationPending))
+	switch {
+	case errors.Is(err, repositories.ErrAuthorizationPending):
+		writeDeviceJSON(w, http.StatusBadRequest, protocol.DeviceTokenResponse{Error: protocol.DeviceErrorPending})
+	case errors.Is(err, repositories.ErrAuthorizationExpired):
+		writeDeviceJSON(w, http.StatusBadRequest, protocol.DeviceTokenResponse{Error: protocol.DeviceErrorExpired, ErrorDescription: "the code expired, run gtc login again"})
+	case err != nil:
+		logger.Errorf("Failed to issue device token: %v", err)
+		writeDeviceJSON(w, http.StatusServiceUnavailable, protocol.DeviceTokenResponse{Error: "temporarily_unavailable", ErrorDescription: err.Error()})
+	default:
+		logger.WithField("device", device.Name).Infof("Device %s logged in", device.ID)
+		writeDeviceJSON(w, http.StatusOK, protocol.DeviceTokenResponse{AccessToken: token, DeviceID: device.ID})
+	}
+}
+
+// pollTooSoon reports whether a pending login is polled again before its interval, the poll
+// then counts as the last one so a client that keeps polling keeps getting slow_down
+func (h *DeviceHandlers) pollTooSoon(deviceCode string) bool {
+	h.pollsMu.Lock()
+	defer h.pollsMu.Unlock()
+	last, ok := h.polls[deviceCode]
+	if !ok || time.Since(last) >= models.DevicePollInterval-pollTolerance {
+		return false
+	}
+	h.polls[deviceCode] = time.Now()
+	return true
+}
+
+// recordPoll remembers when a login still pending was polled, and forgets the others.
+// Only known codes are kept, and only until they expire.
+func (h *DeviceHandlers) recordPoll(deviceCode string, pending bool) {
+	h.pollsMu.Lock()
+	defer h.pollsMu.Unlock()
+	if h.polls == nil {
+		h.polls = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for code, last := range h.polls {
+		if now.Sub(last) > models.DeviceCodeTTL {
+			delete(h.polls, code)
+		}
+	}
+	if pending {
+		h.polls[deviceCode] = now
+	} else {
+		delete(h.polls, deviceCode)
+	}
+}
+
+// Page shows the approval form, and approves a code when posted with the server access token
+func (h *DeviceHandlers) Page(w http.ResponseWriter, r *http.Request) {
+	data := devicePageData{UserCode: r.URL.Query().Get("code")}
+
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, maxDeviceRequestSize)
+		data.UserCode = r.PostFormValue("user_code")
+		if err := checkAdminToken(r.PostFormValue("admin_token")); err != nil {
+			logger.WithField("remote_addr", utils.ClientIP(r, h.TrustedProxies)).Warnf("Rejected device approval: %v", err)
+			data.Error = err.Error()
+		} else if auth, err := h.Devices.Approve(data.UserCode); err != nil {
+			data.Error = "Could not approve the code: " + err.Error()
+		} else {
+			logger.Infof("Device login %s for %q approved from the web page", auth.UserCode, auth.Name)
+			data.Approved = auth.Name
+			data.UserCode = ""
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := devicePage.Execute(w, data); err != nil {
+		logger.Errorf("Failed to render device page: %v", err)
+	}
+}
+
+// checkAdminToken compares a token with the server access token, the web page is
+// disabled when no access token is configured since anyone could approve devices
+func checkAdminToken(token string) error {
+	config, err := repositories.NewServerConfigRepo().Load()
+	if err != nil {
+		return errors.New("could not load the server config")
+	}
+	if config.AccessToken == "" {
+		return errors.New("approving from the web needs a server access token, use 'gts approve <code>' on the server")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.AccessToken)) != 1 {
+		return errors.New("invalid server access token")
+	}
+	return nil
+}
+
+func writeDeviceJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
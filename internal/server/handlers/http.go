@@ -1,92 +1,249 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"time"
 
-	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/har"
+	"github.com/B-AJ-Amar/gTunnel/internal/headers"
 	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/errorpages"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/usage"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/utils"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
 )
 
-func HTTPToWebSocketHandler(w http.ResponseWriter, r *http.Request, pathTunnelRouter func(*http.Request, map[string]*models.ServerTunnelConn) (*models.ServerTunnelConn, string, string), connections map[string]*models.ServerTunnelConn) {
+// Options carries the server-wide settings used by HTTPToWebSocketHandler
+type Options struct {
+	Pages          *errorpages.Renderer
+	TrustedProxies []*net.IPNet
+	Guards         []Guard      // run in order before a request is forwarded
+	Usage          *usage.Meter // counts the traffic of tunnels and throttles the ones over quota
+}
+
+// Guard checks a public request before it is forwarded to its tunnel. When the request
+// must not go through, it writes the response itself and returns false.
+type Guard func(w http.ResponseWriter, r *http.Request, tunnel *models.ServerTunnelConn, requestID string) bool
+
+func HTTPToWebSocketHandler(w http.ResponseWriter, r *http.Request, pathTunnelRouter func(*http.Request, map[string]*models.ServerTunnelConn) (*models.ServerTunnelConn, string, string), connections map[string]*models.ServerTunnelConn, opts *Options) {
+	requestID := uuid.New().String()
+	pages := opts.Pages
+
 	tunnel, _, endpoint := pathTunnelRouter(r, connections)
 
 	if tunnel == nil {
-		http.Error(w, "No tunnel connected", http.StatusServiceUnavailable)
+		pages.Error(w, r, http.StatusServiceUnavailable, errorpages.CodeTunnelOffline, "", requestID)
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	log := tunnel.Log.WithFields(logrus.Fields{
+		"request_id": requestID,
+		"method":     r.Method,
+		"path":       endpoint,
+	})
+
+	for _, guard := range opts.Guards {
+		if !guard(w, r, tunnel, requestID) {
+			return
+		}
+	}
+
+	limits := tunnel.Limits.WithDefaults()
+	if r.ContentLength > limits.MaxRequestBody {
+		log.Infof("Rejected request body of %d bytes", r.ContentLength)
+		pages.Error(w, r, http.StatusRequestEntityTooLarge, errorpages.CodeRequestTooLarge, tooLargeDetail(limits.MaxRequestBody), requestID)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxRequestBody))
 	if err != nil {
-		http.Error(w, "Read error", http.StatusInternalServerError)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			log.Info("Rejected request body over the size limit")
+			pages.Error(w, r, http.StatusRequestEntityTooLarge, errorpages.CodeRequestTooLarge, tooLargeDetail(limits.MaxRequestBody), requestID)
+			return
+		}
+		pages.Error(w, r, http.StatusInternalServerError, errorpages.CodeInternalError, "Failed to read request body", requestID)
 		return
 	}
 	defer r.Body.Close()
 
+	// record is written to the tunnel's HAR file, if any, once the request is answered
+	record := har.Record{
+		StartedAt:      time.Now(),
+		Method:         r.Method,
+		URL:            utils.RequestScheme(r, opts.TrustedProxies) + "://" + r.Host + r.URL.RequestURI(),
+		RequestHeaders: r.Header,
+		RequestBody:    body,
+		Comment:        "request " + requestID,
+	}
+	if tunnel.HAR != nil {
+		defer func() {
+			record.Duration = time.Since(record.StartedAt)
+			if err := tunnel.HAR.Write(record); err != nil {
+				log.Errorf("Failed to write HAR entry: %v", err)
+			}
+		}()
+	}
+	fail := func(status int, code, detail string) {
+		pages.Error(w, r, status, code, detail, requestID)
+		record.Status = status
+		record.ResponseHeaders = w.Header()
+		record.Error = code
+	}
+
 	reqMsg := protocol.HTTPRequestMessage{
 		Method:  r.Method,
 		URL:     endpoint + "?" + r.URL.RawQuery,
-		Headers: map[string]string{},
+		Host:    r.Host,
+		Headers: http.Header{},
 		Body:    body,
 	}
-	for name, values := range r.Header {
+	vars := headers.Vars{
+		ClientIP:  utils.ClientIP(r, opts.TrustedProxies),
+		TunnelID:  tunnel.ID,
+		RequestID: requestID,
+		BaseURL:   tunnel.BaseURL,
+		Host:      r.Host,
+		Method:    r.Method,
+		Path:      endpoint,
+	}
+	requestHeaders := r.Header.Clone()
+	if tunnel.ForwardedHeaders {
+		prefix := ""
+		if tunnel.Paths.StripsPrefix() {
+			prefix = "/" + tunnel.BaseURL
+		}
+		utils.SetForwardedHeaders(requestHeaders, r, prefix, opts.TrustedProxies)
+	} else {
+		utils.StripForwardedHeaders(requestHeaders, r, opts.TrustedProxies)
+	}
+	tunnel.Headers.ApplyRequest(requestHeaders, vars)
+	for name, values := range requestHeaders {
 		if len(values) > 0 {
-			reqMsg.Headers[name] = values[0]
+			reqMsg.Headers[name] = values
 		}
 	}
 
 	payload, err := protocol.SerializeMessage(reqMsg)
 	if err != nil {
-		http.Error(w, "Serialization error", http.StatusInternalServerError)
+		fail(http.StatusInternalServerError, errorpages.CodeInternalError, "Serialization error")
 		return
 	}
 
 	fullMsg := protocol.SocketMessage{
+		ID:      requestID,
 		Type:    protocol.MessageTypeHTTPRequest,
 		Payload: payload,
 	}
 
 	encoded, err := protocol.SerializeMessage(fullMsg)
 	if err != nil {
-		http.Error(w, "Message encoding failed", http.StatusInternalServerError)
+		fail(http.StatusInternalServerError, errorpages.CodeInternalError, "Message encoding failed")
 		return
 	}
 
-	if err := tunnel.Conn.WriteMessage(websocket.TextMessage, encoded); err != nil {
-		http.Error(w, "Tunnel write failed", http.StatusBadGateway)
+	responseCh := tunnel.AddPending(requestID)
+	defer tunnel.RemovePending(requestID)
+
+	throttle := opts.Usage.Throttle(tunnel)
+	if err := throttle.Wait(r.Context(), len(body), responseTimeout(tunnel)); err != nil {
+		if errors.Is(err, usage.ErrThrottledTooLong) {
+			log.Info("Rejected request, the throttled quota would delay it past the response timeout")
+			fail(http.StatusTooManyRequests, errorpages.CodeQuotaExceeded, "A quota is used up and throttles this tunnel too much for this request.")
+			return
+		}
+		log.Info("Client went away while the request was throttled")
+		return
+	}
+	if err := tunnel.WriteMessage(websocket.TextMessage, encoded); err != nil {
+		log.Errorf("Tunnel write failed: %v", err)
+		fail(http.StatusBadGateway, errorpages.CodeTunnelWriteFailed, "")
 		return
 	}
-	logger.Info("HTTPToWebSocketHandler: message sent to tunnel")
+	log.Info("Request sent to tunnel")
+	transferred := int64(len(body))
+	defer func() { opts.Usage.Record(tunnel, transferred) }()
 
 	select {
-	case responseData := <-tunnel.ResponseCh:
+	case responseData := <-responseCh:
 		var responseMsg protocol.SocketMessage
 		if err := protocol.DeserializeMessage(responseData, &responseMsg); err != nil {
-			http.Error(w, "Invalid tunnel response", http.StatusInternalServerError)
+			fail(http.StatusBadGateway, errorpages.CodeInvalidTunnelReply, "Invalid tunnel response")
+			return
+		}
+
+		if responseMsg.Type == protocol.MessageTypeError {
+			var errMsg protocol.ErrorMessage
+			if err := protocol.DeserializeMessage(responseMsg.Payload, &errMsg); err != nil {
+				fail(http.StatusBadGateway, errorpages.CodeInvalidTunnelReply, "Invalid error payload")
+				return
+			}
+			status := errorpages.StatusForUpstreamCode(errMsg.Code)
+			log.WithFields(logrus.Fields{"status": status, "code": errMsg.Code}).Warnf("Local service error: %s", errMsg.Message)
+			fail(status, string(errMsg.Code), errMsg.Message)
 			return
 		}
 
 		if responseMsg.Type != protocol.MessageTypeHTTPResponse {
-			http.Error(w, "Unexpected message type", http.StatusInternalServerError)
+			fail(http.StatusBadGateway, errorpages.CodeInvalidTunnelReply, "Unexpected message type")
 			return
 		}
 
 		var httpResp protocol.HTTPResponseMessage
 		if err := protocol.DeserializeMessage(responseMsg.Payload, &httpResp); err != nil {
-			http.Error(w, "Invalid response payload", http.StatusInternalServerError)
+			fail(http.StatusBadGateway, errorpages.CodeInvalidTunnelReply, "Invalid response payload")
 			return
 		}
 
-		for name, value := range httpResp.Headers {
-			w.Header().Set(name, value)
+		if int64(len(httpResp.Body)) > limits.MaxResponseBody {
+			log.Warnf("Rejected response body of %d bytes", len(httpResp.Body))
+			fail(http.StatusBadGateway, string(protocol.ErrorCodeResponseTooLarge), tooLargeDetail(limits.MaxResponseBody))
+			return
 		}
+
+		for name, values := range httpResp.Headers {
+			for _, value := range values {
+				w.Header().Add(name, value)
+			}
+		}
+		tunnel.CORS.Apply(w.Header(), r.Header.Get("Origin"))
+		if tunnel.Paths.RewritesResponses() {
+			public := &url.URL{Scheme: utils.RequestScheme(r, opts.TrustedProxies), Host: r.Host}
+			tunnel.Paths.RewriteResponse(w.Header(), "/"+tunnel.BaseURL, public)
+		}
+		tunnel.Headers.ApplyResponse(w.Header(), vars)
 		w.WriteHeader(httpResp.StatusCode)
-		w.Write(httpResp.Body)
+		if err := throttle.Write(r.Context(), w, httpResp.Body); err != nil {
+			log.Warnf("Failed to write response: %v", err)
+		}
+		transferred += int64(len(httpResp.Body))
+		log.WithField("status", httpResp.StatusCode).Info("Response returned")
+
+		record.Status = httpResp.StatusCode
+		record.ResponseHeaders = w.Header()
+		record.ResponseBody = httpResp.Body
+
+	case <-time.After(responseTimeout(tunnel)):
+		log.Warn("Tunnel response timeout")
+		fail(http.StatusGatewayTimeout, errorpages.CodeTunnelTimeout, "")
+	}
+}
+
+func tooLargeDetail(limit int64) string {
+	return fmt.Sprintf("The limit is %d bytes.", limit)
+}
 
-	case <-time.After(10 * time.Second):
-		http.Error(w, "Tunnel response timeout", http.StatusGatewayTimeout)
+func responseTimeout(tunnel *models.ServerTunnelConn) time.Duration {
+	if tunnel.ResponseTimeout > 0 {
+		return tunnel.ResponseTimeout
 	}
+	return models.DefaultResponseTimeout
 }
@@ -1,13 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"sync"
 
 	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
 )
 
 // TODO : Move Tunnel FUnctions to the repo
@@ -26,18 +29,23 @@ func EstablishWSConn(w http.ResponseWriter, r *http.Request) (*websocket.Conn, e
 
 func SaveTunnel(conn *websocket.Conn, authenticating map[string]*models.ServerTunnelConn, connMu *sync.Mutex) *models.ServerTunnelConn {
 	id := uuid.New().String()
+	remoteAddr := conn.RemoteAddr().String()
 	tunnel := &models.ServerTunnelConn{
 		ID:         id,
 		Conn:       conn,
-		ResponseCh: make(chan []byte),
 		BaseURL:    "",
+		RemoteAddr: remoteAddr,
+		Log: logger.WithFields(logrus.Fields{
+			"tunnel_id":   id,
+			"remote_addr": remoteAddr,
+		}),
 	}
 
 	connMu.Lock()
 	authenticating[id] = tunnel
 	connMu.Unlock()
 
-	logger.Infof("New connection established: %s", id)
+	tunnel.Log.Info("New connection established")
 	return tunnel
 }
 
@@ -48,7 +56,7 @@ func MoveTunnelToConnections(id string, authenticating map[string]*models.Server
 	if tunnel, ok := authenticating[id]; ok {
 		delete(authenticating, id)
 		connections[id] = tunnel
-		logger.Infof("Tunnel moved to connections: %s", id)
+		tunnel.Log.Info("Tunnel moved to connections")
 	}
 }
 
@@ -58,25 +66,34 @@ func TunnelCleanup(id string, conn *websocket.Conn, connections map[string]*mode
 		delete(connections, id)
 		connMu.Unlock()
 		conn.Close()
-		logger.Infof("Connection closed: %s", id)
+		logger.WithField("tunnel_id", id).Info("Connection closed")
 	}
 }
 
 func HandleWSMessages(tunnel *models.ServerTunnelConn) {
 	for {
 		_, message, err := tunnel.Conn.ReadMessage()
+		if errors.Is(err, websocket.ErrReadLimit) {
+			tunnel.Log.Errorf("Closing tunnel, it sent a message larger than %d bytes", tunnel.Limits.MaxMessageSize)
+			break
+		}
 		if err != nil {
-			logger.Error("Read error:", err)
+			tunnel.Log.Errorf("Read error: %v", err)
 			break
 		}
 
-		logger.Debugf("[%s] Received: %s", tunnel.ID, message)
+		var socketMsg protocol.SocketMessage
+		if err := protocol.DeserializeMessage(message, &socketMsg); err != nil {
+			tunnel.Log.Errorf("Error deserializing message: %v", err)
+			continue
+		}
+
+		log := tunnel.Log.WithField("request_id", socketMsg.ID)
+		log.Debugf("Received: %s", message)
 
-		// Send to response channel (non-blocking)
-		select {
-		case tunnel.ResponseCh <- message:
-		default:
-			logger.Warnf("[%s] WARNING: Dropping message - no listener waiting", tunnel.ID)
+		// Hand the message to the HTTP handler waiting for this request
+		if !tunnel.Deliver(socketMsg.ID, message) {
+			log.Warn("Dropping message - no listener waiting")
 		}
 	}
 }
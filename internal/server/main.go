@@ -1,15 +1,29 @@
 package server
 
 import (
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/B-AJ-Amar/gTunnel/internal/headers"
 	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/cors"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/errorpages"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/handlers"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/oidc"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/ratelimit"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/repositories"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/sec"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/usage"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/utils"
 	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -20,10 +34,41 @@ var (
 
 	connections = make(map[string]*models.ServerTunnelConn)
 	connMu      sync.Mutex
+
+	serverConfig = &models.ServerConfig{}
+	handlerOpts  = &handlers.Options{}
+
+	// authLimiter limits the tunnel handshakes, device logins and device approvals of each IP, nil when disabled
+	authLimiter *ratelimit.Keyed
+	// pollLimiter limits the device token polls of each IP
+	pollLimiter = ratelimit.NewKeyed(models.DevicePollLimit)
 )
 
-func wsHandler(w http.ResponseWriter, r *http.Request) {
+// authLimited rejects the requests of an IP over the auth rate limit before calling next
+func authLimited(next http.HandlerFunc) http.HandlerFunc {
+	return limited(authLimiter, "auth", next)
+}
 
+// pollLimited rejects the device token polls of an IP over models.DevicePollLimit before calling next
+func pollLimited(next http.HandlerFunc) http.HandlerFunc {
+	return limited(pollLimiter, "device poll", next)
+}
+
+// limited rejects the requests of an IP over limiter before calling next, the limiter has to be
+// set up before the routes are
+func limited(limiter *ratelimit.Keyed, name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientIP := utils.ClientIP(r, handlerOpts.TrustedProxies)
+		if ok, wait := limiter.Take(clientIP); !ok {
+			logger.WithFields(logrus.Fields{"client_ip": clientIP, "path": r.URL.Path}).Warnf("Rejected request over the %s rate limit", name)
+			sec.TooManyRequests(w, r, handlerOpts.Pages, wait, "")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func wsHandler(w http.ResponseWriter, r *http.Request) {
 	conn, err := handlers.EstablishWSConn(w, r)
 	if err != nil {
 		return
@@ -32,28 +77,32 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	tunnel := handlers.SaveTunnel(conn, authenticating, &connMu)
 	id := tunnel.ID
 
-	success, err := sec.HandleWSAuth(tunnel, r, authenticating, &authMu, connections, &connMu)
+	success, err := sec.HandleWSAuth(tunnel, r, authenticating, &authMu, connections, &connMu, serverConfig)
 
 	if err != nil {
-		logger.Errorf("Authentication error: %v", err)
+		tunnel.Log.Errorf("Authentication error: %v", err)
 		conn.Close()
 		return
 	}
 	if !success {
-		logger.Warnf("Authentication failed for tunnel: %s", id)
+		tunnel.Log.Warn("Authentication failed")
 		conn.Close()
 		return
 	}
-	logger.Info("Authentication successful")
+	tunnel.Log.Info("Authentication successful")
+	handlerOpts.Usage.SendReport(tunnel)
 
 	handlers.HandleWSMessages(tunnel)
 
 	handlers.TunnelCleanup(id, conn, connections, &connMu)()
+	if err := tunnel.HAR.Close(); err != nil {
+		tunnel.Log.Errorf("Failed to close HAR file: %v", err)
+	}
 }
 
 func httpToWebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	// TODO: add more routers later
-	handlers.HTTPToWebSocketHandler(w, r, utils.PathTunnelRouter, connections)
+	handlers.HTTPToWebSocketHandler(w, r, utils.PathTunnelRouter, connections, handlerOpts)
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -62,22 +111,127 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"healthy","service":"gtunnel-server"}`))
 }
 
-func StartServer(addr string) {
+// watchRevokedDevices closes the tunnels of devices revoked with gts devices revoke
+func watchRevokedDevices(devices repositories.DeviceRepository) {
+	ticker := time.NewTicker(models.DeviceRevocationInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sec.CloseRevokedTunnels(devices, connections, &connMu)
+	}
+}
+
+// saveUsageOnExit saves the usage counted since the last flush when the server is stopped
+func saveUsageOnExit(meter *usage.Meter) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	if err := meter.Flush(); err != nil && !errors.Is(err, repositories.ErrUsageUnavailable) {
+		logger.Errorf("Failed to save usage: %v", err)
+	}
+	logger.Infof("Received %s, shutting down", sig)
+	os.Exit(0)
+}
+
+func StartServer(addr string, config *models.ServerConfig) {
+	if config != nil {
+		serverConfig = config
+	}
+
+	pages, err := errorpages.New(serverConfig.ErrorPages.Dir)
+	if err != nil {
+		logger.Errorf("Failed to load custom error pages, using built-in pages: %v", err)
+		pages, err = errorpages.New("")
+		if err != nil {
+			logger.Fatalf("Failed to load error pages: %v", err)
+		}
+	}
+	handlerOpts.Pages = pages
+
+	trusted, err := utils.ParseTrustedProxies(serverConfig.TrustedProxies)
+	if err != nil {
+		logger.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	handlerOpts.TrustedProxies = trusted
+	for key, policy := range serverConfig.TokenPolicies {
+		if _, err := sec.NewAccessPolicy(&protocol.AuthRequestMessage{}, key, policy); err != nil {
+			logger.Fatalf("Invalid token policy %q: %v", key, err)
+		}
+		if _, err := headers.Compile(policy.Headers.Rules()); err != nil {
+			logger.Fatalf("Invalid token policy %q: %v", key, err)
+		}
+	}
+
+	defaultCORS, err := cors.New(serverConfig.CORS.Policy())
+	if err != nil {
+		logger.Fatalf("Invalid cors config: %v", err)
+	}
+	logger.Infof("Default CORS mode for tunnels: %s", defaultCORS.Mode)
+
+	perIPLimit, err := ratelimit.Parse(serverConfig.RateLimits.PerIP.Rate, serverConfig.RateLimits.PerIP.Burst)
+	if err != nil {
+		logger.Fatalf("Invalid rate_limits.per_ip: %v", err)
+	}
+	authRateLimit := serverConfig.RateLimits.Auth
+	if authRateLimit.Rate == "" {
+		authRateLimit = models.DefaultAuthRateLimit
+	}
+	authLimit, err := ratelimit.Parse(authRateLimit.Rate, authRateLimit.Burst)
+	if err != nil {
+		logger.Fatalf("Invalid rate_limits.auth: %v", err)
+	}
+	authLimiter = ratelimit.NewKeyed(authLimit)
+	basicAuthRateLimit := serverConfig.RateLimits.BasicAuth
+	if basicAuthRateLimit.Rate == "" {
+		basicAuthRateLimit = models.DefaultBasicAuthRateLimit
+	}
+	basicAuthLimit, err := ratelimit.Parse(basicAuthRateLimit.Rate, basicAuthRateLimit.Burst)
+	if err != nil {
+		logger.Fatalf("Invalid rate_limits.basic_auth: %v", err)
+	}
+	if perIPLimit.Enabled() {
+		logger.Infof("Rate limit per client IP: %s", perIPLimit)
+	}
+
+	gate, err := oidc.NewGate(serverConfig.OIDC, pages, trusted)
+	if err != nil {
+		logger.Fatalf("Invalid OIDC config: %v", err)
+	}
+	if gate.Enabled() {
+		logger.Infof("OIDC sign-in available with issuer %s", serverConfig.OIDC.Issuer)
+		logger.Warn("Tunnels are routed by path and share one origin, so OIDC does not isolate them from each other: only enable it when every tunnel owner is trusted")
+	}
+
+	meter, err := usage.NewMeter(repositories.NewUsageRepo(), serverConfig)
+	if err != nil {
+		logger.Fatalf("Failed to load usage: %v", err)
+	}
+	handlerOpts.Usage = meter
+	go meter.Run(models.UsageFlushInterval)
+	go saveUsageOnExit(meter)
+
+	handlerOpts.Guards = []handlers.Guard{
+		sec.RateLimitGuard(pages, trusted, ratelimit.NewKeyed(perIPLimit)),
+		meter.Guard(pages),
+		sec.IPFilterGuard(pages, trusted),
+		sec.CORSGuard(pages),
+		gate.Guard,
+		sec.BasicAuthGuard(pages, trusted, ratelimit.NewKeyed(basicAuthLimit)),
+	}
+
 	r := chi.NewRouter()
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	})
-	r.Get("/___gTl___/ws", wsHandler)
+	r.Get("/___gTl___/ws", authLimited(wsHandler))
 	r.Get("/___gTl___/health", healthHandler)
+
+	devices := repositories.NewDeviceRepo()
+	deviceHandlers := &handlers.DeviceHandlers{Devices: devices, TrustedProxies: trusted}
+	r.Post(protocol.DeviceCodePath, authLimited(deviceHandlers.Code))
+	r.Post(protocol.DeviceTokenPath, pollLimited(deviceHandlers.Token))
+	r.Get(protocol.DeviceVerifyPath, deviceHandlers.Page)
+	r.Post(protocol.DeviceVerifyPath, authLimited(deviceHandlers.Page))
+	go watchRevokedDevices(devices)
+	r.Get(oidc.CallbackPath, gate.Callback)
+	r.Get(oidc.LogoutPath, gate.Logout)
+	r.Post(oidc.LogoutPath, gate.Logout)
 	r.NotFound(httpToWebSocketHandler)
 
 	logger.Infof("Server listening on %s", addr)
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/server/ratelimit"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AccessPolicy restricts who can reach the public URL of a tunnel
+type AccessPolicy struct {
+	BasicAuth  []HashedCredential // any of them is accepted, empty disables basic auth
+	IPFilters  []IPFilter         // a client IP must pass all of them
+	OIDC       *OIDCRule          // visitors must sign in with the OIDC provider, nil disables it
+	RateLimits []RateLimiter      // a request must get a token from all of them
+}
+
+// RateLimiter is the request bucket of a tunnel, from the tunnel or from its token policy
+type RateLimiter struct {
+	Source string
+	Limit  ratelimit.Limit
+	Bucket *ratelimit.Bucket
+}
+
+// IPFilter is an allow and deny list of networks, from the tunnel or from its token policy
+type IPFilter struct {
+	Source string // "tunnel" or "token policy", for logs
+	Allow  []*net.IPNet
+	Deny   []*net.IPNet
+}
+
+// Allows reports whether ip is not denied and, when there is an allow list, is in it
+func (f IPFilter) Allows(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range f.Deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, n := range f.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifiedCredentialTTL is how long accepted credentials skip bcrypt, browsers send them with
+// every request and a page with many assets would otherwise cost a bcrypt check for each
+const verifiedCredentialTTL = time.Minute
+
+// maxVerifiedCredentials bounds the accepted passwords remembered per credential
+const maxVerifiedCredentials = 64
+
+// HashedCredential is a basic auth user with a password kept only as a bcrypt hash
+type HashedCredential struct {
+	username string
+	hash     []byte
+	verified *verifiedCredentials
+}
+
+// verifiedCredentials remembers the passwords accepted recently, as HMACs with a random key
+type verifiedCredentials struct {
+	mu   sync.Mutex
+	key  []byte
+	seen map[string]time.Time
+}
+
+func NewHashedCredential(username, password string) (HashedCredential, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return HashedCredential{}, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return HashedCredential{}, err
+	}
+	return HashedCredential{
+		username: username,
+		hash:     hash,
+		verified: &verifiedCredentials{key: key, seen: make(map[string]time.Time)},
+	}, nil
+}
+
+// Matches reports whether username and password are the ones the credential was made from
+func (c HashedCredential) Matches(username, password string) bool {
+	if subtle.ConstantTimeCompare([]byte(username), []byte(c.username)) != 1 {
+		return false
+	}
+	mac := c.verified.mac(password)
+	if c.verified.recent(mac) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(c.hash, []byte(password)) != nil {
+		return false
+	}
+	c.verified.add(mac)
+	return true
+}
+
+// Recent reports whether username and password were accepted by Matches less than
+// verifiedCredentialTTL ago, without a bcrypt check
+func (c HashedCredential) Recent(username, password string) bool {
+	return subtle.ConstantTimeCompare([]byte(username), []byte(c.username)) == 1 && c.verified.recent(c.verified.mac(password))
+}
+
+func (v *verifiedCredentials) mac(password string) string {
+	h := hmac.New(sha256.New, v.key)
+	h.Write([]byte(password))
+	return string(h.Sum(nil))
+}
+
+func (v *verifiedCredentials) recent(mac string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	at, ok := v.seen[mac]
+	return ok && time.Since(at) < verifiedCredentialTTL
+}
+
+func (v *verifiedCredentials) add(mac string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for seen, at := range v.seen {
+		if now.Sub(at) >= verifiedCredentialTTL {
+			delete(v.seen, seen)
+		}
+	}
+	if len(v.seen) >= maxVerifiedCredentials {
+		clear(v.seen)
+	}
+	v.seen[mac] = now
+}
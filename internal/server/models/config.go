@@ -1,6 +1,107 @@
 package models
 
+import (
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+)
+
+const (
+	DefaultAuthTimeout        = 10 * time.Second
+	DefaultResponseTimeout    = 10 * time.Second
+	DefaultMaxResponseTimeout = 5 * time.Minute
+)
+
 // just a temp solution i will inhance the auth later
 type ServerConfig struct {
-	AccessToken string `mapstructure:"access_token"`
+	AccessToken string        `mapstructure:"access_token"`
+	Log         logger.Config `mapstructure:"log"`
+	Timeouts    TimeoutConfig `mapstructure:"timeouts"`
+	ErrorPages  ErrorPages    `mapstructure:"error_pages"`
+	HAR         HARConfig     `mapstructure:"har"`
+
+	// TrustedProxies lists the IPs/CIDRs of proxies in front of the server whose
+	// X-Forwarded-* headers are kept, anything else is replaced
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+
+	// Limits bound request, response and websocket message sizes, token policies can override them
+	Limits SizeLimits `mapstructure:"limits"`
+
+	// RateLimits throttle public requests per client IP and tunnel handshakes
+	RateLimits RateLimitConfig `mapstructure:"rate_limits"`
+
+	// CORS is how cross-origin requests are handled for tunnels that do not choose themselves
+	CORS CORSConfig `mapstructure:"cors"`
+
+	// OIDC signs in the visitors of the tunnels opened with gtc connect --oidc
+	OIDC OIDCConfig `mapstructure:"oidc"`
+
+	// TokenPolicies restrict the tunnels of a token, keyed by device ID, "shared" or "default"
+	TokenPolicies map[string]TokenPolicy `mapstructure:"token_policies"`
+}
+
+type ErrorPages struct {
+	Dir string `mapstructure:"dir"` // directory with custom error templates, empty uses the built-in page
+}
+
+// HARConfig controls the recording of tunnel traffic as HAR files on the server
+type HARConfig struct {
+	Dir           string   `mapstructure:"dir"`            // directory of the HAR files, recording is off when empty
+	All           bool     `mapstructure:"all"`            // record every tunnel, not only the ones asking for it
+	MaxBodySize   int      `mapstructure:"max_body_size"`  // bytes written per body
+	RedactHeaders []string `mapstructure:"redact_headers"` // defaults to Authorization, Proxy-Authorization, Cookie and Set-Cookie
+}
+
+// DefaultAuthRateLimit slows down token guessing on /___gTl___/ws unless rate_limits.auth is set
+var DefaultAuthRateLimit = RateLimit{Rate: "20/m"}
+
+// DefaultBasicAuthRateLimit bounds the bcrypt password checks of a client IP unless rate_limits.basic_auth is set
+var DefaultBasicAuthRateLimit = RateLimit{Rate: "30/m", Burst: 10}
+
+type RateLimitConfig struct {
+	PerIP     RateLimit `mapstructure:"per_ip"`     // public requests of a client IP, across all tunnels
+	Auth      RateLimit `mapstructure:"auth"`       // tunnel handshakes of an IP, "off" disables it
+	BasicAuth RateLimit `mapstructure:"basic_auth"` // basic auth passwords an IP can have checked, "off" disables it
+}
+
+// RateLimit is a token-bucket limit
+type RateLimit struct {
+	Rate  string `mapstructure:"rate"`  // "100/s", "600/m", "10/30s", empty or "off" for no limit
+	Burst int    `mapstructure:"burst"` // requests allowed at once, defaults to the count of Rate
+}
+
+type TimeoutConfig struct {
+	Auth        time.Duration `mapstructure:"auth"`         // time a new connection has to authenticate
+	Response    time.Duration `mapstructure:"response"`     // time to wait for a tunnel to answer a request
+	MaxResponse time.Duration `mapstructure:"max_response"` // upper bound for per-tunnel response timeouts
+}
+
+// WithDefaults fills the unset timeouts with their default values
+func (t TimeoutConfig) WithDefaults() TimeoutConfig {
+	if t.Auth <= 0 {
+		t.Auth = DefaultAuthTimeout
+	}
+	if t.Response <= 0 {
+		t.Response = DefaultResponseTimeout
+	}
+	if t.MaxResponse <= 0 {
+		t.MaxResponse = DefaultMaxResponseTimeout
+	}
+	if t.MaxResponse < t.Response {
+		t.MaxResponse = t.Response
+	}
+	return t
+}
+
+// TunnelResponseTimeout returns the response timeout for a tunnel that asked for `requested`,
+// falling back to the server default and capped at MaxResponse
+func (t TimeoutConfig) TunnelResponseTimeout(requested time.Duration) time.Duration {
+	t = t.WithDefaults()
+	if requested <= 0 {
+		return t.Response
+	}
+	if requested > t.MaxResponse {
+		return t.MaxResponse
+	}
+	return requested
 }
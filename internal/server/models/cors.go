@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+// CORSConfig is the CORS handling of the tunnels that do not ask for one in their handshake
+type CORSConfig struct {
+	Mode             string        `mapstructure:"mode"` // passthrough (default), off or policy
+	AllowOrigins     []string      `mapstructure:"allow_origins"`
+	AllowMethods     []string      `mapstructure:"allow_methods"`
+	AllowHeaders     []string      `mapstructure:"allow_headers"`
+	ExposeHeaders    []string      `mapstructure:"expose_headers"`
+	AllowCredentials bool          `mapstructure:"allow_credentials"`
+	MaxAge           time.Duration `mapstructure:"max_age"`
+}
+
+// Policy returns the config in the form tunnels ask for it
+func (c CORSConfig) Policy() protocol.CORSPolicy {
+	return protocol.CORSPolicy{
+		Mode:             c.Mode,
+		AllowOrigins:     c.AllowOrigins,
+		AllowMethods:     c.AllowMethods,
+		AllowHeaders:     c.AllowHeaders,
+		ExposeHeaders:    c.ExposeHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAgeSeconds:    int(c.MaxAge / time.Second),
+	}
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/server/ratelimit"
+)
+
+const (
+	DeviceTokenPrefix        = "gtd_" // marks tokens issued to a device by gtc login
+	DeviceCodeTTL            = 10 * time.Minute
+	DevicePollInterval       = 5 * time.Second
+	DeviceRevocationInterval = 30 * time.Second // how often open tunnels of revoked devices are closed
+)
+
+// DevicePollLimit bounds the device token polls of each IP, enough for a few logins at once
+var DevicePollLimit = ratelimit.Limit{Requests: 30, Per: time.Minute, Burst: 10}
+
+// Device is a client that logged in with the device flow and got its own access token
+type Device struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"token_hash"` // hex SHA-256 of the token, the token itself is never stored
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (d *Device) Revoked() bool {
+	return d.RevokedAt != nil
+}
+
+// DeviceAuthorization is a login waiting for an admin to approve its user code
+type DeviceAuthorization struct {
+	DeviceCode string     `json:"device_code"`
+	UserCode   string     `json:"user_code"`
+	Name       string     `json:"name"`
+	RemoteAddr string     `json:"remote_addr"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	ApprovedAt *time.Time `json:"approved_at,omitempty"`
+}
+
+// DeviceStore is the content of the devices file
+type DeviceStore struct {
+	Devices []Device              `json:"devices"`
+	Pending []DeviceAuthorization `json:"pending"`
+}
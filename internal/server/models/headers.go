@@ -0,0 +1,29 @@
+package models
+
+import "github.com/B-AJ-Amar/gTunnel/internal/protocol"
+
+// HeaderRule changes a header of the requests or responses of the tunnels of a token
+type HeaderRule struct {
+	Action string `mapstructure:"action"` // add, set or remove
+	Name   string `mapstructure:"name"`
+	Value  string `mapstructure:"value"` // can use placeholders, e.g. "{client_ip}"
+}
+
+// HeaderRules are applied after the ones of the tunnel, so they win over them
+type HeaderRules struct {
+	Request  []HeaderRule `mapstructure:"request"`  // before requests reach the local app
+	Response []HeaderRule `mapstructure:"response"` // before responses are returned
+}
+
+// Rules returns the rules in the form tunnels send them
+func (r HeaderRules) Rules() protocol.HeaderRules {
+	return protocol.HeaderRules{Request: convertHeaderRules(r.Request), Response: convertHeaderRules(r.Response)}
+}
+
+func convertHeaderRules(list []HeaderRule) []protocol.HeaderRule {
+	rules := make([]protocol.HeaderRule, 0, len(list))
+	for _, r := range list {
+		rules = append(rules, protocol.HeaderRule{Action: r.Action, Name: r.Name, Value: r.Value})
+	}
+	return rules
+}
@@ -0,0 +1,45 @@
+package models
+
+const (
+	DefaultMaxRequestBody  = 32 << 20 // 32 MiB
+	DefaultMaxResponseBody = 64 << 20 // 64 MiB
+
+	// MaxAuthMessageSize bounds the handshake read from a connection before it is authenticated
+	MaxAuthMessageSize = 64 << 10
+)
+
+// SizeLimits bound what a tunnel can make the server hold in memory, in bytes
+type SizeLimits struct {
+	MaxRequestBody  int64 `mapstructure:"max_request_body"`  // public request bodies, larger ones get a 413
+	MaxResponseBody int64 `mapstructure:"max_response_body"` // bodies returned by the local service, larger ones get a 502
+	MaxMessageSize  int64 `mapstructure:"max_message_size"`  // websocket messages from the client, defaults to fit the largest response
+}
+
+// WithDefaults fills the unset limits with their default values
+func (l SizeLimits) WithDefaults() SizeLimits {
+	if l.MaxRequestBody <= 0 {
+		l.MaxRequestBody = DefaultMaxRequestBody
+	}
+	if l.MaxResponseBody <= 0 {
+		l.MaxResponseBody = DefaultMaxResponseBody
+	}
+	if l.MaxMessageSize <= 0 {
+		// bodies are base64 encoded in messages, plus room for the headers
+		l.MaxMessageSize = l.MaxResponseBody/3*4 + 1<<20
+	}
+	return l
+}
+
+// Override returns the limits with the ones set in other replacing them
+func (l SizeLimits) Override(other SizeLimits) SizeLimits {
+	if other.MaxRequestBody > 0 {
+		l.MaxRequestBody = other.MaxRequestBody
+	}
+	if other.MaxResponseBody > 0 {
+		l.MaxResponseBody = other.MaxResponseBody
+	}
+	if other.MaxMessageSize > 0 {
+		l.MaxMessageSize = other.MaxMessageSize
+	}
+	return l
+}
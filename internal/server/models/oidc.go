@@ -0,0 +1,79 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	DefaultOIDCSessionTTL  = 12 * time.Hour
+	DefaultOIDCGroupsClaim = "groups"
+)
+
+// OIDCConfig is the OpenID Connect provider used to sign in visitors of the tunnels that ask for it
+type OIDCConfig struct {
+	Issuer       string        `mapstructure:"issuer"` // discovery is read from <issuer>/.well-known/openid-configuration
+	ClientID     string        `mapstructure:"client_id"`
+	ClientSecret string        `mapstructure:"client_secret"`
+	RedirectURL  string        `mapstructure:"redirect_url"`  // defaults to the callback path on the host of the request
+	Scopes       []string      `mapstructure:"scopes"`        // defaults to openid, email and profile
+	GroupsClaim  string        `mapstructure:"groups_claim"`  // ID token claim holding the groups, defaults to "groups"
+	CookieSecret string        `mapstructure:"cookie_secret"` // signs the session cookies, random on each start when empty
+	SessionTTL   time.Duration `mapstructure:"session_ttl"`   // how long a sign-in lasts, defaults to 12h
+}
+
+// Enabled reports whether an issuer is configured
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// WithDefaults fills the unset fields with their default values
+func (c OIDCConfig) WithDefaults() OIDCConfig {
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = DefaultOIDCGroupsClaim
+	}
+	if c.SessionTTL <= 0 {
+		c.SessionTTL = DefaultOIDCSessionTTL
+	}
+	return c
+}
+
+// OIDCRule restricts a tunnel to visitors signed in with the OIDC provider.
+// Each non-empty list must match, empty lists accept any signed-in visitor.
+type OIDCRule struct {
+	EmailDomains []string
+	Groups       []string
+}
+
+// Allows reports whether a visitor with this verified email and these groups may use the tunnel
+func (r OIDCRule) Allows(email string, emailVerified bool, groups []string) bool {
+	if len(r.EmailDomains) > 0 {
+		at := strings.LastIndex(email, "@")
+		if !emailVerified || at < 0 || !containsFold(r.EmailDomains, email[at+1:]) {
+			return false
+		}
+	}
+	if len(r.Groups) > 0 {
+		for _, group := range groups {
+			for _, allowed := range r.Groups {
+				if group == allowed {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimPrefix(v, "@"), value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package models
+
+const (
+	PolicySharedToken = "shared"  // token_policies key of the tunnels opened with the shared access token
+	PolicyDefault     = "default" // token_policies key used when a token has no policy of its own
+)
+
+// TokenPolicy holds the restrictions an admin sets for the tunnels opened with a token.
+// They apply on top of the ones a tunnel asks for in its handshake.
+type TokenPolicy struct {
+	AllowCIDRs []string   `mapstructure:"allow_cidrs"` // only these networks may reach the tunnels
+	DenyCIDRs  []string   `mapstructure:"deny_cidrs"`  // these networks may not, even if allowed
+	RateLimit  RateLimit  `mapstructure:"rate_limit"`  // public requests per tunnel
+	Limits     SizeLimits `mapstructure:"limits"`      // replace the server-wide size limits
+
+	Headers HeaderRules `mapstructure:"headers"` // rewrite request and response headers
+
+	Quota       Quota `mapstructure:"quota"`        // traffic of all the tunnels of the token together
+	TunnelQuota Quota `mapstructure:"tunnel_quota"` // traffic of each tunnel of the token
+}
+
+// TokenPolicy returns the policy of a device, or of the shared access token when deviceID is empty
+func (c *ServerConfig) TokenPolicy(deviceID string) TokenPolicy {
+	key := deviceID
+	if key == "" {
+		key = PolicySharedToken
+	}
+	if policy, ok := c.TokenPolicies[key]; ok {
+		return policy
+	}
+	return c.TokenPolicies[PolicyDefault]
+}
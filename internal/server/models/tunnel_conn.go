@@ -1,12 +1,82 @@
 package models
 
 import (
+	"sync"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/har"
+	"github.com/B-AJ-Amar/gTunnel/internal/headers"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/cors"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/paths"
 	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
 )
 
 type ServerTunnelConn struct {
 	ID         string
 	Conn       *websocket.Conn
-	ResponseCh chan []byte
 	BaseURL    string // ? for the first version , base url should be only one level deep , e.g /app-1 , // later we can make it more complex
+	RemoteAddr string
+	DeviceID   string        // device whose token opened the tunnel, empty for the shared access token
+	Log        *logrus.Entry // carries tunnel_id, remote_addr and base_url once known
+
+	ResponseTimeout  time.Duration  // how long HTTP handlers wait for this tunnel to answer
+	ForwardedHeaders bool           // add X-Forwarded-* and Forwarded headers to requests
+	HAR              *har.Writer    // records the tunnel's traffic, nil when recording is off
+	Access           AccessPolicy   // checked on every public request before it is forwarded
+	Limits           SizeLimits     // size limits of the tunnel's token, with defaults applied
+	CORS             *cors.Policy   // CORS handling of the tunnel, nil leaves it to the local app
+	Headers          headers.Rules  // the header rules of the tunnel, then the ones of its token policy
+	Paths            *paths.Options // path options of the tunnel, nil strips the prefix
+
+	writeMu   sync.Mutex
+	pendingMu sync.Mutex
+	pending   map[string]chan []byte // request ID -> waiting HTTP handler
+}
+
+// WriteMessage serializes writes to the websocket, gorilla allows only one concurrent writer
+func (t *ServerTunnelConn) WriteMessage(messageType int, data []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.Conn.WriteMessage(messageType, data)
+}
+
+// WriteJSON is the JSON counterpart of WriteMessage
+func (t *ServerTunnelConn) WriteJSON(v interface{}) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.Conn.WriteJSON(v)
+}
+
+// AddPending registers a request ID and returns the channel its response will be delivered on
+func (t *ServerTunnelConn) AddPending(requestID string) chan []byte {
+	t.pendingMu.Lock()
+	defer t.pendingMu.Unlock()
+
+	if t.pending == nil {
+		t.pending = make(map[string]chan []byte)
+	}
+	ch := make(chan []byte, 1)
+	t.pending[requestID] = ch
+	return ch
+}
+
+func (t *ServerTunnelConn) RemovePending(requestID string) {
+	t.pendingMu.Lock()
+	defer t.pendingMu.Unlock()
+	delete(t.pending, requestID)
+}
+
+// Deliver hands a message to the handler waiting for requestID, it returns false if nobody is waiting
+func (t *ServerTunnelConn) Deliver(requestID string, message []byte) bool {
+	t.pendingMu.Lock()
+	ch, ok := t.pending[requestID]
+	delete(t.pending, requestID)
+	t.pendingMu.Unlock()
+
+	if !ok {
+		return false
+	}
+	ch <- message
+	return true
 }
@@ -0,0 +1,137 @@
+package models
+
+import "time"
+
+const (
+	// UsageFlushInterval is how often the server writes usage to disk, gts usage can be this far behind
+	UsageFlushInterval = 30 * time.Second
+	// UsageWarnFraction of a quota is when clients are told they are close to it
+	UsageWarnFraction = 0.8
+
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// Quota caps the traffic of a token or tunnel per UTC day and month, zero fields are unlimited
+type Quota struct {
+	DailyBytes      int64 `mapstructure:"daily_bytes"`
+	MonthlyBytes    int64 `mapstructure:"monthly_bytes"`
+	DailyRequests   int64 `mapstructure:"daily_requests"`
+	MonthlyRequests int64 `mapstructure:"monthly_requests"`
+	Throttle        int64 `mapstructure:"throttle"` // bytes per second once over quota, 0 rejects requests instead
+}
+
+func (q Quota) Enabled() bool {
+	return q.DailyBytes > 0 || q.MonthlyBytes > 0 || q.DailyRequests > 0 || q.MonthlyRequests > 0
+}
+
+// QuotaItem is one limit of a quota with the usage counted against it
+type QuotaItem struct {
+	Name     string // daily_bytes, monthly_bytes, daily_requests or monthly_requests
+	Used     int64
+	Limit    int64
+	ResetsAt time.Time
+}
+
+func (i QuotaItem) Fraction() float64 {
+	return float64(i.Used) / float64(i.Limit)
+}
+
+// Items lists the limits set in the quota with their current usage
+func (q Quota) Items(usage UsageRecord, now time.Time) []QuotaItem {
+	usage = usage.At(now)
+	nextDay, nextMonth := periodEnds(now)
+	var items []QuotaItem
+	for _, item := range []QuotaItem{
+		{"daily_bytes", usage.Daily.Bytes, q.DailyBytes, nextDay},
+		{"monthly_bytes", usage.Monthly.Bytes, q.MonthlyBytes, nextMonth},
+		{"daily_requests", usage.Daily.Requests, q.DailyRequests, nextDay},
+		{"monthly_requests", usage.Monthly.Requests, q.MonthlyRequests, nextMonth},
+	} {
+		if item.Limit > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Exceeded returns the used up limit that resets last, or nil when there is none
+func (q Quota) Exceeded(usage UsageRecord, now time.Time) *QuotaItem {
+	var exceeded *QuotaItem
+	for _, item := range q.Items(usage, now) {
+		if item.Used >= item.Limit && (exceeded == nil || item.ResetsAt.After(exceeded.ResetsAt)) {
+			item := item
+			exceeded = &item
+		}
+	}
+	return exceeded
+}
+
+type UsageCounter struct {
+	Requests int64 `json:"requests"`
+	Bytes    int64 `json:"bytes"` // request and response bodies
+}
+
+func (c *UsageCounter) add(requests, bytes int64) {
+	c.Requests += requests
+	c.Bytes += bytes
+}
+
+// UsageRecord is the traffic of a token or tunnel for the current UTC day and month
+type UsageRecord struct {
+	Day      string       `json:"day"`
+	Daily    UsageCounter `json:"daily"`
+	Month    string       `json:"month"`
+	Monthly  UsageCounter `json:"monthly"`
+	Total    UsageCounter `json:"total"`
+	LastSeen time.Time    `json:"last_seen"`
+	Token    string       `json:"token,omitempty"` // for tunnels, the token that opened it last
+}
+
+// At returns the record as seen at now, with the counters of past periods reset
+func (r UsageRecord) At(now time.Time) UsageRecord {
+	now = now.UTC()
+	if day := now.Format(dayLayout); r.Day != day {
+		r.Day, r.Daily = day, UsageCounter{}
+	}
+	if month := now.Format(monthLayout); r.Month != month {
+		r.Month, r.Monthly = month, UsageCounter{}
+	}
+	return r
+}
+
+// Stale reports whether the record has no traffic in the month of now
+func (r UsageRecord) Stale(now time.Time) bool {
+	return r.Month != now.UTC().Format(monthLayout)
+}
+
+// Add counts traffic made at now
+func (r *UsageRecord) Add(now time.Time, requests, bytes int64) {
+	*r = r.At(now)
+	r.Daily.add(requests, bytes)
+	r.Monthly.add(requests, bytes)
+	r.Total.add(requests, bytes)
+	r.LastSeen = now
+}
+
+// UsageStore is the traffic of every token, keyed like token policies, and every tunnel, keyed by base URL
+type UsageStore struct {
+	Tokens    map[string]*UsageRecord `json:"tokens"`
+	Tunnels   map[string]*UsageRecord `json:"tunnels"`
+	UpdatedAt time.Time               `json:"updated_at"`
+}
+
+func periodEnds(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	return day, month
+}
+
+// TokenUsageKey returns the key of a token in UsageStore.Tokens, the same as in token_policies
+func TokenUsageKey(deviceID string) string {
+	if deviceID == "" {
+		return PolicySharedToken
+	}
+	return deviceID
+}
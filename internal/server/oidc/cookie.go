@@ -0,0 +1,78 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var errInvalidCookie = errors.New("invalid or expired cookie")
+
+// session is what the session cookie holds once a visitor signed in
+type session struct {
+	Subject       string   `json:"sub"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	Groups        []string `json:"groups,omitempty"`
+	Expires       int64    `json:"exp"`
+}
+
+// loginState is what the state cookie holds while a visitor is at the provider's sign-in page
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+	ReturnTo string `json:"return_to"`
+	Expires  int64  `json:"exp"`
+}
+
+// signer encodes values as base64(JSON).base64(HMAC-SHA256) so they can be kept in cookies.
+// The purpose is part of the MAC so a state cookie can't be replayed as a session.
+type signer struct {
+	key []byte
+}
+
+func (s signer) sign(purpose string, v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(purpose, encoded)), nil
+}
+
+// verify checks the MAC of a signed value and decodes it into v
+func (s signer) verify(purpose, value string, v interface{}) error {
+	encoded, mac, ok := strings.Cut(value, ".")
+	if !ok {
+		return errInvalidCookie
+	}
+	got, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil || !hmac.Equal(got, s.mac(purpose, encoded)) {
+		return errInvalidCookie
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return errInvalidCookie
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return errInvalidCookie
+	}
+	return nil
+}
+
+func (s signer) mac(purpose, encoded string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
+
+func expired(unix int64) bool {
+	return time.Now().Unix() >= unix
+}
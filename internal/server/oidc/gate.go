@@ -0,0 +1,285 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/errorpages"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	CallbackPath = "/___gTl___/oidc/callback"
+	LogoutPath   = "/___gTl___/oidc/logout"
+
+	// cookiePrefix is shared by every gTunnel cookie, they are never forwarded to tunnels
+	cookiePrefix      = "gtunnel_"
+	sessionCookie     = cookiePrefix + "session"
+	stateCookiePrefix = cookiePrefix + "oidc_"
+	loginTTL          = 10 * time.Minute
+
+	purposeSession = "session"
+	purposeState   = "state"
+)
+
+// identityHeaders tell the local service who signed in, values sent by the visitor are dropped
+var identityHeaders = struct{ User, Email, Groups string }{"X-GTunnel-User", "X-GTunnel-Email", "X-GTunnel-Groups"}
+
+// Gate signs in the visitors of OIDC protected tunnels with the authorization code flow
+// and keeps them signed in with a session cookie shared by all the tunnels of the server.
+// Tunnels are routed by path, so they all share the server's origin: a page served by one
+// tunnel can make requests to another with the visitor's session. Isolating them needs
+// host-based routing, which the server does not have yet.
+type Gate struct {
+	config   models.OIDCConfig
+	provider *Provider // nil when no issuer is configured
+	cookies  signer
+	pages    *errorpages.Renderer
+	trusted  []*net.IPNet
+}
+
+func NewGate(config models.OIDCConfig, pages *errorpages.Renderer, trusted []*net.IPNet) (*Gate, error) {
+	gate := &Gate{config: config.WithDefaults(), pages: pages, trusted: trusted}
+	if !config.Enabled() {
+		return gate, nil
+	}
+	if config.ClientID == "" {
+		return nil, errors.New("oidc.client_id is required with oidc.issuer")
+	}
+
+	key := []byte(config.CookieSecret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		logger.Warn("oidc.cookie_secret is not set, visitors will have to sign in again after a restart")
+	} else if len(key) < 16 {
+		return nil, errors.New("oidc.cookie_secret must be at least 16 characters")
+	}
+	gate.cookies = signer{key: key}
+	gate.provider = NewProvider(config)
+	return gate, nil
+}
+
+// Enabled reports whether tunnels can ask for OIDC sign-in
+func (g *Gate) Enabled() bool {
+	return g.provider != nil
+}
+
+// Guard lets through the visitors of OIDC protected tunnels that signed in and match the tunnel's
+// rule, and sends the others to the provider. gTunnel cookies and identity headers sent by the
+// visitor are removed from every request so no tunnel can read another one's sessions.
+func (g *Gate) Guard(w http.ResponseWriter, r *http.Request, tunnel *models.ServerTunnelConn, requestID string) bool {
+	current, hasSession := g.readSession(r)
+	removeGTunnelCookies(r)
+	r.Header.Del(identityHeaders.User)
+	r.Header.Del(identityHeaders.Email)
+	r.Header.Del(identityHeaders.Groups)
+
+	rule := tunnel.Access.OIDC
+	if rule == nil {
+		return true
+	}
+	log := tunnel.Log.WithField("request_id", requestID)
+	if !g.Enabled() {
+		log.Error("Tunnel asks for OIDC sign-in but no provider is configured")
+		g.pages.Error(w, r, http.StatusInternalServerError, errorpages.CodeInternalError, "", requestID)
+		return false
+	}
+
+	if hasSession {
+		if !rule.Allows(current.Email, current.EmailVerified, current.Groups) {
+			log.WithFields(logrus.Fields{"subject": current.Subject, "email": current.Email}).Info("Rejected signed-in visitor not allowed by the OIDC rule")
+			g.pages.Error(w, r, http.StatusForbidden, errorpages.CodeIdentityNotAllowed, signedInAs(current), requestID)
+			return false
+		}
+		r.Header.Set(identityHeaders.User, current.Subject)
+		if current.Email != "" {
+			r.Header.Set(identityHeaders.Email, current.Email)
+		}
+		if len(current.Groups) > 0 {
+			r.Header.Set(identityHeaders.Groups, strings.Join(current.Groups, ","))
+		}
+		return true
+	}
+
+	// only page loads can follow the redirect to the provider
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		g.pages.Error(w, r, http.StatusUnauthorized, errorpages.CodeLoginRequired, "", requestID)
+		return false
+	}
+	if err := g.startLogin(w, r); err != nil {
+		log.Errorf("Failed to start OIDC sign-in: %v", err)
+		g.pages.Error(w, r, http.StatusBadGateway, errorpages.CodeLoginFailed, "The identity provider could not be reached.", requestID)
+		return false
+	}
+	return false
+}
+
+// startLogin keeps the state, nonce and PKCE verifier in a cookie scoped to the callback
+// and redirects to the provider
+func (g *Gate) startLogin(w http.ResponseWriter, r *http.Request) error {
+	login := loginState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString() + randomString(),
+		ReturnTo: r.URL.RequestURI(),
+		Expires:  time.Now().Add(loginTTL).Unix(),
+	}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	target, err := g.provider.AuthCodeURL(r.Context(), g.redirectURL(r), login.State, login.Nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		return err
+	}
+	value, err := g.cookies.sign(purposeState, login)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, g.cookie(r, stateCookiePrefix+login.State, value, CallbackPath, loginTTL))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+	return nil
+}
+
+// Callback finishes a sign-in started by Guard and sends the visitor back to the tunnel URL
+func (g *Gate) Callback(w http.ResponseWriter, r *http.Request) {
+	if !g.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	state := query.Get("state")
+
+	var login loginState
+	cookie, err := r.Cookie(stateCookiePrefix + state)
+	if state == "" || err != nil || g.cookies.verify(purposeState, cookie.Value, &login) != nil || login.State != state || expired(login.Expires) {
+		g.pages.Error(w, r, http.StatusBadRequest, errorpages.CodeLoginFailed, "The sign-in expired or was started in another browser. Open the tunnel URL again.", "")
+		return
+	}
+	http.SetCookie(w, g.cookie(r, stateCookiePrefix+state, "", CallbackPath, -1))
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		logger.WithField("error", providerErr).Warnf("OIDC sign-in refused by the provider: %s", query.Get("error_description"))
+		g.pages.Error(w, r, http.StatusForbidden, errorpages.CodeLoginFailed, "The identity provider refused the sign-in.", "")
+		return
+	}
+
+	claims, err := g.provider.Exchange(r.Context(), query.Get("code"), g.redirectURL(r), login.Verifier, login.Nonce)
+	if err != nil {
+		logger.Errorf("OIDC sign-in failed: %v", err)
+		g.pages.Error(w, r, http.StatusBadGateway, errorpages.CodeLoginFailed, "The identity provider could not confirm the sign-in.", "")
+		return
+	}
+
+	value, err := g.cookies.sign(purposeSession, session{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Groups:        claims.Groups,
+		Expires:       time.Now().Add(g.config.SessionTTL).Unix(),
+	})
+	if err != nil {
+		g.pages.Error(w, r, http.StatusInternalServerError, errorpages.CodeInternalError, "", "")
+		return
+	}
+	logger.WithFields(logrus.Fields{"subject": claims.Subject, "email": claims.Email}).Info("Visitor signed in with OIDC")
+
+	http.SetCookie(w, g.cookie(r, sessionCookie, value, "/", g.config.SessionTTL))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, safeReturnTo(login.ReturnTo), http.StatusFound)
+}
+
+// Logout removes the session cookie
+func (g *Gate) Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, g.cookie(r, sessionCookie, "", "/", -1))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("Signed out of gTunnel\n"))
+}
+
+func (g *Gate) readSession(r *http.Request) (session, bool) {
+	var s session
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || !g.Enabled() {
+		return s, false
+	}
+	if g.cookies.verify(purposeSession, cookie.Value, &s) != nil || expired(s.Expires) {
+		return s, false
+	}
+	return s, true
+}
+
+// redirectURL is the configured redirect URL or the callback path on the host of the request
+func (g *Gate) redirectURL(r *http.Request) string {
+	if g.config.RedirectURL != "" {
+		return g.config.RedirectURL
+	}
+	return utils.RequestScheme(r, g.trusted) + "://" + r.Host + CallbackPath
+}
+
+func (g *Gate) cookie(r *http.Request, name, value, path string, ttl time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   utils.RequestScheme(r, g.trusted) == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(ttl.Seconds())
+	}
+	return cookie
+}
+
+// removeGTunnelCookies drops the gTunnel cookies from the Cookie header and keeps the others
+func removeGTunnelCookies(r *http.Request) {
+	cookies := r.Cookies()
+	kept := cookies[:0]
+	for _, c := range cookies {
+		if !strings.HasPrefix(c.Name, cookiePrefix) {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == len(cookies) {
+		return
+	}
+	r.Header.Del("Cookie")
+	for _, c := range kept {
+		r.AddCookie(c)
+	}
+}
+
+// safeReturnTo only allows paths on this server, not other hosts
+func safeReturnTo(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+func signedInAs(s session) string {
+	if s.Email != "" {
+		return "Signed in as " + s.Email + "."
+	}
+	return ""
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,172 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, signed string) []byte {
+	t.Helper()
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, signed string) []byte {
+	t.Helper()
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature
+}
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const signed = "header.payload"
+	rsaSignature := signRS256(t, rsaKey, signed)
+	ecSignature := signES256(t, ecKey, signed)
+
+	tests := []struct {
+		name      string
+		alg       string
+		key       crypto.PublicKey
+		signed    string
+		signature []byte
+		wantErr   bool
+	}{
+		{"RS256", "RS256", &rsaKey.PublicKey, signed, rsaSignature, false},
+		{"ES256", "ES256", &ecKey.PublicKey, signed, ecSignature, false},
+		{"RS256 tampered", "RS256", &rsaKey.PublicKey, signed + "x", rsaSignature, true},
+		{"ES256 tampered", "ES256", &ecKey.PublicKey, signed + "x", ecSignature, true},
+		{"RS256 other key", "RS256", &otherRSAKey.PublicKey, signed, rsaSignature, true},
+		{"ES256 short signature", "ES256", &ecKey.PublicKey, signed, ecSignature[:63], true},
+		{"none", "none", &rsaKey.PublicKey, signed, nil, true},
+		{"HS256", "HS256", &rsaKey.PublicKey, signed, rsaSignature, true},
+		{"RS alg with EC key", "RS256", &ecKey.PublicKey, signed, ecSignature, true},
+		{"ES alg with RSA key", "ES256", &rsaKey.PublicKey, signed, rsaSignature, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySignature(tt.alg, tt.key, []byte(tt.signed), tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifySignature() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplitJWT(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	header := encode(`{"alg":"RS256","kid":"k1"}`)
+	payload := encode(`{"sub":"alice"}`)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantKid string
+		wantErr bool
+	}{
+		{"valid", header + "." + payload + "." + encode("sig"), "k1", false},
+		{"two parts", header + "." + payload, "", true},
+		{"four parts", header + "." + payload + ".a.b", "", true},
+		{"header not base64", "***." + payload + "." + encode("sig"), "", true},
+		{"header not JSON", encode("nope") + "." + payload + "." + encode("sig"), "", true},
+		{"payload not base64", header + ".***." + encode("sig"), "", true},
+		{"signature not base64", header + "." + payload + ".***", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotPayload, signed, _, err := splitJWT(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitJWT() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Kid != tt.wantKid {
+				t.Errorf("kid = %q, want %q", got.Kid, tt.wantKid)
+			}
+			if string(gotPayload) != `{"sub":"alice"}` {
+				t.Errorf("payload = %q", gotPayload)
+			}
+			if !strings.HasPrefix(tt.token, string(signed)+".") {
+				t.Errorf("signing input %q is not the start of the token", signed)
+			}
+		})
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	encodeInt := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     jwk
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{
+			name: "RSA",
+			key:  jwk{Kty: "RSA", N: encodeInt(rsaKey.N), E: encodeInt(big.NewInt(int64(rsaKey.E)))},
+			want: &rsaKey.PublicKey,
+		},
+		{
+			name: "EC P-256",
+			key:  jwk{Kty: "EC", Crv: "P-256", X: encodeInt(ecKey.X), Y: encodeInt(ecKey.Y)},
+			want: &ecKey.PublicKey,
+		},
+		{name: "unsupported curve", key: jwk{Kty: "EC", Crv: "P-521"}, wantErr: true},
+		{name: "unsupported type", key: jwk{Kty: "oct"}, wantErr: true},
+		{name: "invalid modulus", key: jwk{Kty: "RSA", N: "***", E: "AQAB"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.key.publicKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("publicKey() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !got.(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.want) {
+				t.Errorf("publicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package paths
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+type rewrite struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+// Options are the path options of a tunnel. A nil value strips the tunnel prefix
+// and leaves responses as they are.
+type Options struct {
+	Mode string
+
+	rewrites     []rewrite
+	location     bool
+	cookiePath   bool
+	upstreamHost string
+}
+
+// New checks path options and compiles their rewrite rules
+func New(p protocol.PathOptions) (*Options, error) {
+	if err := protocol.ValidatePathOptions(p); err != nil {
+		return nil, err
+	}
+	options := &Options{
+		Mode:         p.Mode,
+		location:     p.RewriteLocation,
+		cookiePath:   p.RewriteCookiePath,
+		upstreamHost: p.UpstreamHost,
+	}
+	if options.Mode == "" {
+		options.Mode = protocol.PathModeStrip
+	}
+	for _, r := range p.Rewrites {
+		options.rewrites = append(options.rewrites, rewrite{match: regexp.MustCompile(r.Match), replace: r.Replace})
+	}
+	return options, nil
+}
+
+// Path returns the path sent to the local app for a public path, also given without the tunnel prefix
+func (o *Options) Path(public, stripped string) string {
+	if o == nil {
+		return stripped
+	}
+	switch o.Mode {
+	case protocol.PathModePreserve:
+		return public
+	case protocol.PathModeRewrite:
+		for _, r := range o.rewrites {
+			if r.match.MatchString(stripped) {
+				path := r.match.ReplaceAllString(stripped, r.replace)
+				if !strings.HasPrefix(path, "/") {
+					path = "/" + path
+				}
+				return path
+			}
+		}
+	}
+	return stripped
+}
+
+// StripsPrefix reports whether the local app sees paths without the tunnel prefix
+func (o *Options) StripsPrefix() bool {
+	return o == nil || o.Mode != protocol.PathModePreserve
+}
+
+// RewritesResponses reports whether RewriteResponse changes anything
+func (o *Options) RewritesResponses() bool {
+	return o != nil && (o.location || o.cookiePath)
+}
+
+// RewriteResponse adds the tunnel prefix to the redirects and cookie paths of a response, when
+// enabled and not already there. Absolute redirects are rewritten when they point to the public
+// host, or to the local app, in which case they are moved to the public URL.
+func (o *Options) RewriteResponse(h http.Header, prefix string, public *url.URL) {
+	if !o.RewritesResponses() {
+		return
+	}
+	if location := h.Get("Location"); o.location && location != "" {
+		h.Set("Location", o.rewriteLocation(location, prefix, public))
+	}
+	if cookies := h.Values("Set-Cookie"); o.cookiePath && len(cookies) > 0 {
+		rewritten := make([]string, 0, len(cookies))
+		for _, cookie := range cookies {
+			rewritten = append(rewritten, rewriteCookiePath(cookie, prefix))
+		}
+		h["Set-Cookie"] = rewritten
+	}
+}
+
+func (o *Options) rewriteLocation(location, prefix string, public *url.URL) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	switch {
+	case u.Host == "":
+		// relative redirects like "next" or "?page=2" already stay under the prefix
+		if u.Scheme != "" || !strings.HasPrefix(u.Path, "/") {
+			return location
+		}
+	case strings.EqualFold(u.Host, public.Host):
+	case o.upstreamHost != "" && strings.EqualFold(u.Host, o.upstreamHost):
+		u.Scheme = public.Scheme
+		u.Host = public.Host
+	default:
+		return location // another site
+	}
+
+	if underPrefix(u.Path, prefix) {
+		return u.String()
+	}
+	u.Path = prefix + u.Path
+	if u.RawPath != "" {
+		u.RawPath = prefix + u.RawPath
+	}
+	return u.String()
+}
+
+// rewriteCookiePath moves the Path attribute of a Set-Cookie value under the prefix. Cookies
+// without one are left alone, browsers default them to the directory of the public URL.
+func rewriteCookiePath(cookie, prefix string) string {
+	parts := strings.Split(cookie, ";")
+	for i, part := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if !strings.EqualFold(name, "path") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if !strings.HasPrefix(value, "/") || underPrefix(value, prefix) {
+			continue
+		}
+		if value == "/" {
+			value = ""
+		}
+		parts[i+1] = " Path=" + prefix + value
+	}
+	return strings.Join(parts, ";")
+}
+
+func underPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
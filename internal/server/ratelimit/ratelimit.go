@@ -0,0 +1,181 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+)
+
+// sweepInterval is how often Keyed drops the buckets that refilled, they are the same as new ones
+const sweepInterval = time.Minute
+
+// Limit is a token-bucket limit: Burst requests at once, refilled at Requests per Per
+type Limit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// Parse builds a limit from a rate like "100/m" and an optional burst.
+// The limit is zero, and Enabled false, for an empty rate or "off".
+func Parse(rate string, burst int) (Limit, error) {
+	requests, per, err := protocol.ParseRate(rate)
+	if err != nil || requests == 0 {
+		return Limit{}, err
+	}
+	if burst <= 0 {
+		burst = requests
+	}
+	return Limit{Requests: requests, Per: per, Burst: burst}, nil
+}
+
+func (l Limit) Enabled() bool {
+	return l.Requests > 0
+}
+
+func (l Limit) String() string {
+	per := l.Per.String()
+	switch l.Per {
+	case time.Second:
+		per = "s"
+	case time.Minute:
+		per = "m"
+	case time.Hour:
+		per = "h"
+	}
+	return strconv.Itoa(l.Requests) + "/" + per + " (burst " + strconv.Itoa(l.Burst) + ")"
+}
+
+// Bucket is a token bucket, safe for concurrent use
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(limit Limit) *Bucket {
+	return &Bucket{
+		rate:   float64(limit.Requests) / limit.Per.Seconds(),
+		burst:  float64(limit.Burst),
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Take removes a token if there is one. Otherwise it returns false and how long until one is available.
+func (b *Bucket) Take() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// TakeAll takes a token from every bucket or from none: when one is empty, the tokens already
+// taken are given back and it returns false, the index of that bucket and how long until it has one.
+func TakeAll(buckets []*Bucket) (bool, int, time.Duration) {
+	for i, bucket := range buckets {
+		if ok, wait := bucket.Take(); !ok {
+			for _, taken := range buckets[:i] {
+				taken.Refund(1)
+			}
+			return false, i, wait
+		}
+	}
+	return true, -1, 0
+}
+
+// Reserve takes n tokens, going into debt when there are not enough, and returns how long
+// to wait before using them. It paces a flow of bytes rather than counting requests.
+func (b *Bucket) Reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Refund gives back n tokens reserved but not used
+func (b *Bucket) Refund(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.tokens = math.Min(b.burst, b.tokens+float64(n))
+}
+
+func (b *Bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *Bucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// Keyed keeps one bucket per key, e.g. per client IP. A nil Keyed has no limit.
+type Keyed struct {
+	limit     Limit
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+// NewKeyed returns nil, no limit, when limit is not enabled
+func NewKeyed(limit Limit) *Keyed {
+	if !limit.Enabled() {
+		return nil
+	}
+	return &Keyed{limit: limit, buckets: make(map[string]*Bucket), lastSweep: time.Now()}
+}
+
+// Take takes a token from the bucket of key, see Bucket.Take
+func (k *Keyed) Take(key string) (bool, time.Duration) {
+	if k == nil {
+		return true, 0
+	}
+
+	now := time.Now()
+	k.mu.Lock()
+	if now.Sub(k.lastSweep) > sweepInterval {
+		for key, bucket := range k.buckets {
+			if bucket.full(now) {
+				delete(k.buckets, key)
+			}
+		}
+		k.lastSweep = now
+	}
+	bucket, ok := k.buckets[key]
+	if !ok {
+		bucket = NewBucket(k.limit)
+		k.buckets[key] = bucket
+	}
+	k.mu.Unlock()
+
+	return bucket.Take()
+}
+
+// RetryAfter formats a wait as the whole seconds of a Retry-After header, at least 1
+func RetryAfter(wait time.Duration) string {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rate    string
+		burst   int
+		want    Limit
+		wantErr bool
+	}{
+		{"", 0, Limit{}, false},
+		{"off", 0, Limit{}, false},
+		{"100/m", 0, Limit{Requests: 100, Per: time.Minute, Burst: 100}, false},
+		{"10/s", 50, Limit{Requests: 10, Per: time.Second, Burst: 50}, false},
+		{"5/h", -1, Limit{Requests: 5, Per: time.Hour, Burst: 5}, false},
+		{"ten/s", 0, Limit{}, true},
+		{"10/fortnight", 0, Limit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.rate, tt.burst)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q, %d) error = %v, want error %v", tt.rate, tt.burst, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q, %d) = %+v, want %+v", tt.rate, tt.burst, got, tt.want)
+		}
+	}
+}
+
+func TestBucketTake(t *testing.T) {
+	bucket := NewBucket(Limit{Requests: 1, Per: time.Hour, Burst: 3})
+	for i := 0; i < 3; i++ {
+		if ok, _ := bucket.Take(); !ok {
+			t.Fatalf("take %d refused within the burst", i+1)
+		}
+	}
+	ok, wait := bucket.Take()
+	if ok {
+		t.Fatal("take accepted over the burst")
+	}
+	if wait <= 59*time.Minute || wait > time.Hour {
+		t.Errorf("wait = %v, want about an hour", wait)
+	}
+}
+
+func TestTakeAll(t *testing.T) {
+	limit := Limit{Requests: 1, Per: time.Hour, Burst: 2}
+	tests := []struct {
+		name      string
+		tokens    []int // tokens to take from each bucket before TakeAll
+		wantOK    bool
+		wantIndex int
+		wantLeft  []int // tokens left in each bucket after TakeAll
+	}{
+		{"no buckets", nil, true, -1, nil},
+		{"all have tokens", []int{0, 1}, true, -1, []int{1, 0}},
+		{"first empty", []int{2, 0}, false, 0, []int{0, 2}},
+		{"last empty refunds the others", []int{0, 1, 2}, false, 2, []int{2, 1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := make([]*Bucket, len(tt.tokens))
+			for i, n := range tt.tokens {
+				buckets[i] = NewBucket(limit)
+				for j := 0; j < n; j++ {
+					buckets[i].Take()
+				}
+			}
+
+			ok, index, _ := TakeAll(buckets)
+			if ok != tt.wantOK || index != tt.wantIndex {
+				t.Fatalf("TakeAll() = %v, %d, want %v, %d", ok, index, tt.wantOK, tt.wantIndex)
+			}
+			for i, bucket := range buckets {
+				if left := countTokens(bucket); left != tt.wantLeft[i] {
+					t.Errorf("bucket %d has %d tokens, want %d", i, left, tt.wantLeft[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReserveAndRefund(t *testing.T) {
+	bucket := NewBucket(Limit{Requests: 10, Per: time.Second, Burst: 10})
+	if wait := bucket.Reserve(10); wait != 0 {
+		t.Errorf("Reserve within the burst waits %v", wait)
+	}
+	if wait := bucket.Reserve(5); wait < 400*time.Millisecond || wait > 500*time.Millisecond {
+		t.Errorf("Reserve into debt waits %v, want about 500ms", wait)
+	}
+	bucket.Refund(100)
+	if left := countTokens(bucket); left != 10 {
+		t.Errorf("Refund left %d tokens, want the burst of 10", left)
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	if ok, _ := (*Keyed)(nil).Take("anyone"); !ok {
+		t.Error("a nil Keyed refused a request")
+	}
+	if NewKeyed(Limit{}) != nil {
+		t.Error("NewKeyed of a disabled limit is not nil")
+	}
+
+	keyed := NewKeyed(Limit{Requests: 1, Per: time.Hour, Burst: 1})
+	if ok, _ := keyed.Take("a"); !ok {
+		t.Fatal("first take of a refused")
+	}
+	if ok, _ := keyed.Take("a"); ok {
+		t.Error("second take of a accepted")
+	}
+	if ok, _ := keyed.Take("b"); !ok {
+		t.Error("b shares the bucket of a")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want string
+	}{
+		{0, "1"},
+		{300 * time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{time.Minute, "60"},
+	}
+	for _, tt := range tests {
+		if got := RetryAfter(tt.wait); got != tt.want {
+			t.Errorf("RetryAfter(%v) = %q, want %q", tt.wait, got, tt.want)
+		}
+	}
+}
+
+// countTokens takes the whole tokens left in bucket, within the burst
+func countTokens(bucket *Bucket) int {
+	n := 0
+	for n < int(bucket.burst) {
+		if ok, _ := bucket.Take(); !ok {
+			break
+		}
+		n++
+	}
+	return n
+}
@@ -21,6 +21,47 @@ const (
 	appName    = "gtunnel"
 )
 
+// envBindings maps config keys to the environment variables read in USE_ENV mode
+var envBindings = map[string]string{
+	"access_token":                 "GTUNNEL_ACCESS_TOKEN",
+	"log.level":                    "GTUNNEL_LOG_LEVEL",
+	"log.format":                   "GTUNNEL_LOG_FORMAT",
+	"log.file":                     "GTUNNEL_LOG_FILE",
+	"log.rotation.max_size_mb":     "GTUNNEL_LOG_MAX_SIZE_MB",
+	"log.rotation.interval":        "GTUNNEL_LOG_ROTATE_INTERVAL",
+	"log.rotation.max_backups":     "GTUNNEL_LOG_MAX_BACKUPS",
+	"timeouts.auth":                "GTUNNEL_AUTH_TIMEOUT",
+	"timeouts.response":            "GTUNNEL_RESPONSE_TIMEOUT",
+	"timeouts.max_response":        "GTUNNEL_MAX_RESPONSE_TIMEOUT",
+	"error_pages.dir":              "GTUNNEL_ERROR_PAGES_DIR",
+	"trusted_proxies":              "GTUNNEL_TRUSTED_PROXIES",
+	"har.dir":                      "GTUNNEL_HAR_DIR",
+	"har.all":                      "GTUNNEL_HAR_ALL",
+	"har.max_body_size":            "GTUNNEL_HAR_MAX_BODY_SIZE",
+	"har.redact_headers":           "GTUNNEL_HAR_REDACT_HEADERS",
+	"rate_limits.per_ip.rate":      "GTUNNEL_RATE_LIMIT_PER_IP",
+	"rate_limits.per_ip.burst":     "GTUNNEL_RATE_LIMIT_PER_IP_BURST",
+	"rate_limits.auth.rate":        "GTUNNEL_RATE_LIMIT_AUTH",
+	"rate_limits.auth.burst":       "GTUNNEL_RATE_LIMIT_AUTH_BURST",
+	"rate_limits.basic_auth.rate":  "GTUNNEL_RATE_LIMIT_BASIC_AUTH",
+	"rate_limits.basic_auth.burst": "GTUNNEL_RATE_LIMIT_BASIC_AUTH_BURST",
+	"cors.mode":                    "GTUNNEL_CORS_MODE",
+	"cors.allow_origins":           "GTUNNEL_CORS_ALLOW_ORIGINS",
+	"cors.allow_methods":           "GTUNNEL_CORS_ALLOW_METHODS",
+	"cors.allow_headers":           "GTUNNEL_CORS_ALLOW_HEADERS",
+	"cors.expose_headers":          "GTUNNEL_CORS_EXPOSE_HEADERS",
+	"cors.allow_credentials":       "GTUNNEL_CORS_ALLOW_CREDENTIALS",
+	"cors.max_age":                 "GTUNNEL_CORS_MAX_AGE",
+	"oidc.issuer":                  "GTUNNEL_OIDC_ISSUER",
+	"oidc.client_id":               "GTUNNEL_OIDC_CLIENT_ID",
+	"oidc.client_secret":           "GTUNNEL_OIDC_CLIENT_SECRET",
+	"oidc.redirect_url":            "GTUNNEL_OIDC_REDIRECT_URL",
+	"oidc.scopes":                  "GTUNNEL_OIDC_SCOPES",
+	"oidc.groups_claim":            "GTUNNEL_OIDC_GROUPS_CLAIM",
+	"oidc.cookie_secret":           "GTUNNEL_OIDC_COOKIE_SECRET",
+	"oidc.session_ttl":             "GTUNNEL_OIDC_SESSION_TTL",
+}
+
 type ServerConfigRepository interface {
 	InitConfig() error
 	Load() (*models.ServerConfig, error)
@@ -92,7 +133,9 @@ func (r *ServerConfigRepo) Load() (*models.ServerConfig, error) {
 
 	if r.useEnv {
 		viper.AutomaticEnv()
-		_ = viper.BindEnv("access_token", "GTUNNEL_ACCESS_TOKEN")
+		for key, env := range envBindings {
+			_ = viper.BindEnv(key, env)
+		}
 	}
 
 	if err := viper.Unmarshal(&config); err != nil {
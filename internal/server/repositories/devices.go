@@ -0,0 +1,378 @@
+package repositories
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/google/uuid"
+)
+
+const (
+	devicesFileName = "devices.json"
+
+	// user codes avoid vowels and look-alike characters so they are easy to read out and type
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+	lockRetryInterval = 20 * time.Millisecond
+	lockTimeout       = 5 * time.Second
+	staleLockAge      = 30 * time.Second
+)
+
+var (
+	ErrDevicesUnavailable   = errors.New("device login needs a config directory, it is not available in USE_ENV mode")
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrAuthorizationExpired = errors.New("authorization expired")
+	ErrUnknownCode          = errors.New("unknown or expired code")
+	ErrDeviceNotFound       = errors.New("device not found")
+	ErrInvalidDeviceToken   = errors.New("invalid or revoked device token")
+)
+
+// DeviceRepository keeps the devices logged in with gtc login and the logins waiting for approval.
+// It is shared by the running server and the gts approve/devices commands through a file.
+type DeviceRepository interface {
+	CreateAuthorization(name, remoteAddr string) (*models.DeviceAuthorization, error)
+	Approve(userCode string) (*models.DeviceAuthorization, error)
+	IssueToken(deviceCode string) (string, *models.Device, error)
+	Authenticate(token string) (*models.Device, error)
+	List() (*models.DeviceStore, error)
+	Revoke(id string) (*models.Device, error)
+}
+
+type DeviceRepo struct {
+	path string // empty in USE_ENV mode
+}
+
+func NewDeviceRepo() DeviceRepository {
+	if os.Getenv("GTUNNEL_USE_ENV") == "true" {
+		return &DeviceRepo{}
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = "."
+	}
+	return &DeviceRepo{path: filepath.Join(configDir, appName, devicesFileName)}
+}
+
+func (r *DeviceRepo) CreateAuthorization(name, remoteAddr string) (*models.DeviceAuthorization, error) {
+	deviceCode, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth models.DeviceAuthorization
+	err = r.update(func(store *models.DeviceStore) error {
+		userCode, err := newUserCode(store)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		auth = models.DeviceAuthorization{
+			DeviceCode: deviceCode,
+			UserCode:   userCode,
+			Name:       name,
+			RemoteAddr: remoteAddr,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(models.DeviceCodeTTL),
+		}
+		store.Pending = append(store.Pending, auth)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+func (r *DeviceRepo) Approve(userCode string) (*models.DeviceAuthorization, error) {
+	userCode = normalizeUserCode(userCode)
+
+	var approved models.DeviceAuthorization
+	err := r.update(func(store *models.DeviceStore) error {
+		for i := range store.Pending {
+			auth := &store.Pending[i]
+			if auth.UserCode != userCode {
+				continue
+			}
+			if auth.ApprovedAt == nil {
+				now := time.Now()
+				auth.ApprovedAt = &now
+			}
+			approved = *auth
+			return nil
+		}
+		return ErrUnknownCode
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &approved, nil
+}
+
+// IssueToken creates the device and its token once its login is approved. The token is
+// returned only this once.
+func (r *DeviceRepo) IssueToken(deviceCode string) (string, *models.Device, error) {
+	var token string
+	var device models.Device
+	err := r.update(func(store *models.DeviceStore) error {
+		for i, auth := range store.Pending {
+			if subtle.ConstantTimeCompare([]byte(auth.DeviceCode), []byte(deviceCode)) != 1 {
+				continue
+			}
+			if time.Now().After(auth.ExpiresAt) {
+				return ErrAuthorizationExpired
+			}
+			if auth.ApprovedAt == nil {
+				return ErrAuthorizationPending
+			}
+
+			secret, err := randomToken(32)
+			if err != nil {
+				return err
+			}
+			token = models.DeviceTokenPrefix + secret
+			device = models.Device{
+				ID:        uuid.New().String(),
+				Name:      auth.Name,
+				TokenHash: hashToken(token),
+				CreatedAt: time.Now(),
+			}
+			store.Devices = append(store.Devices, device)
+			store.Pending = append(store.Pending[:i], store.Pending[i+1:]...)
+			return nil
+		}
+		// expired logins are pruned from the file, so an unknown code may just be an old one
+		return ErrAuthorizationExpired
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &device, nil
+}
+
+// Authenticate returns the device a token was issued to, and records when it was last used
+func (r *DeviceRepo) Authenticate(token string) (*models.Device, error) {
+	hash := hashToken(token)
+
+	var device models.Device
+	err := r.update(func(store *models.DeviceStore) error {
+		for i := range store.Devices {
+			d := &store.Devices[i]
+			if subtle.ConstantTimeCompare([]byte(d.TokenHash), []byte(hash)) != 1 {
+				continue
+			}
+			if d.Revoked() {
+				return ErrInvalidDeviceToken
+			}
+			now := time.Now()
+			d.LastUsedAt = &now
+			device = *d
+			return nil
+		}
+		return ErrInvalidDeviceToken
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func (r *DeviceRepo) List() (*models.DeviceStore, error) {
+	if r.path == "" {
+		return nil, ErrDevicesUnavailable
+	}
+	store, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	pruneExpired(store)
+	return store, nil
+}
+
+// Revoke revokes a device by ID or by a prefix matching a single device
+func (r *DeviceRepo) Revoke(id string) (*models.Device, error) {
+	var revoked models.Device
+	err := r.update(func(store *models.DeviceStore) error {
+		var match *models.Device
+		for i := range store.Devices {
+			d := &store.Devices[i]
+			if d.ID == id {
+				match = d
+				break
+			}
+			if strings.HasPrefix(d.ID, id) {
+				if match != nil {
+					return fmt.Errorf("%q matches more than one device", id)
+				}
+				match = d
+			}
+		}
+		if match == nil || id == "" {
+			return ErrDeviceNotFound
+		}
+		if !match.Revoked() {
+			now := time.Now()
+			match.RevokedAt = &now
+		}
+		revoked = *match
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &revoked, nil
+}
+
+// update applies fn to the devices file while holding its lock, and writes it back if fn succeeds
+func (r *DeviceRepo) update(fn func(store *models.DeviceStore) error) error {
+	if r.path == "" {
+		return ErrDevicesUnavailable
+	}
+
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := r.read()
+	if err != nil {
+		return err
+	}
+	pruneExpired(store)
+	if err := fn(store); err != nil {
+		return err
+	}
+	return r.write(store)
+}
+
+func (r *DeviceRepo) read() (*models.DeviceStore, error) {
+	store := &models.DeviceStore{}
+	data, err := os.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read devices file: %w", err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("could not parse devices file %s: %w", r.path, err)
+	}
+	return store, nil
+}
+
+// write replaces the devices file atomically, it holds token hashes so only the owner can read it
+func (r *DeviceRepo) write(store *models.DeviceStore) error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return fmt.Errorf("could not create config directory: %w", err)
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("could not write devices file: %w", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("could not write devices file: %w", err)
+	}
+	return nil
+}
+
+// lock takes a lock file next to the devices file, the server and the gts commands run as separate processes
+func (r *DeviceRepo) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return nil, fmt.Errorf("could not create config directory: %w", err)
+	}
+
+	lockPath := r.path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("could not lock devices file: %w", err)
+		}
+		// a crashed process may have left its lock behind
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the devices file lock %s", lockPath)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func pruneExpired(store *models.DeviceStore) {
+	now := time.Now()
+	pending := store.Pending[:0]
+	for _, auth := range store.Pending {
+		if now.Before(auth.ExpiresAt) {
+			pending = append(pending, auth)
+		}
+	}
+	store.Pending = pending
+}
+
+func newUserCode(store *models.DeviceStore) (string, error) {
+	alphabetSize := big.NewInt(int64(len(userCodeAlphabet)))
+	for {
+		code := make([]byte, 8)
+		for i := range code {
+			// rand.Int is uniform, a random byte modulo the alphabet size would favour its first letters
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return "", err
+			}
+			code[i] = userCodeAlphabet[n.Int64()]
+		}
+		userCode := string(code[:4]) + "-" + string(code[4:])
+
+		taken := false
+		for _, auth := range store.Pending {
+			taken = taken || auth.UserCode == userCode
+		}
+		if !taken {
+			return userCode, nil
+		}
+	}
+}
+
+// normalizeUserCode accepts codes typed in lowercase or without the dash
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) == 8 {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
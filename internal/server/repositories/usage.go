@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+)
+
+const usageFileName = "usage.json"
+
+var (
+	ErrUsageUnavailable = errors.New("usage is kept in memory only in USE_ENV mode, there is no config directory to save it to")
+	ErrUsageCorrupt     = errors.New("usage file is corrupt")
+)
+
+// UsageRepository persists the traffic counted by the server so quotas survive restarts.
+// Only the server writes it, gts usage reads it.
+type UsageRepository interface {
+	Load() (*models.UsageStore, error)
+	Save(store *models.UsageStore) error
+	Path() string
+	// SetAside renames the usage file so it is kept when a new one is saved, it returns the new path
+	SetAside() (string, error)
+}
+
+type UsageRepo struct {
+	path string // empty in USE_ENV mode
+}
+
+func NewUsageRepo() UsageRepository {
+	if os.Getenv("GTUNNEL_USE_ENV") == "true" {
+		return &UsageRepo{}
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = "."
+	}
+	return &UsageRepo{path: filepath.Join(configDir, appName, usageFileName)}
+}
+
+func (r *UsageRepo) Path() string {
+	return r.path
+}
+
+// Load returns an empty store when nothing was saved yet
+func (r *UsageRepo) Load() (*models.UsageStore, error) {
+	store := &models.UsageStore{}
+	if r.path == "" {
+		return store, ErrUsageUnavailable
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil && !os.IsNotExist(err) {
+		return store, fmt.Errorf("could not read usage file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, store); err != nil {
+			return &models.UsageStore{}, fmt.Errorf("%w: could not parse %s: %v", ErrUsageCorrupt, r.path, err)
+		}
+	}
+	if store.Tokens == nil {
+		store.Tokens = make(map[string]*models.UsageRecord)
+	}
+	if store.Tunnels == nil {
+		store.Tunnels = make(map[string]*models.UsageRecord)
+	}
+	return store, nil
+}
+
+// Save replaces the usage file atomically
+func (r *UsageRepo) Save(store *models.UsageStore) error {
+	if r.path == "" {
+		return ErrUsageUnavailable
+	}
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return fmt.Errorf("could not create config directory: %w", err)
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("could not write usage file: %w", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("could not write usage file: %w", err)
+	}
+	return nil
+}
+
+// SetAside renames the usage file to usage.json.corrupt-<timestamp>
+func (r *UsageRepo) SetAside() (string, error) {
+	if r.path == "" {
+		return "", ErrUsageUnavailable
+	}
+	aside := r.path + ".corrupt-" + time.Now().UTC().Format("20060102T150405Z")
+	if err := os.Rename(r.path, aside); err != nil {
+		return "", fmt.Errorf("could not rename usage file: %w", err)
+	}
+	return aside, nil
+}
@@ -1,22 +1,27 @@
 package sec
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/B-AJ-Amar/gTunnel/internal/headers"
 	"github.com/B-AJ-Amar/gTunnel/internal/logger"
 	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/cors"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/paths"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/repositories"
 	"github.com/B-AJ-Amar/gTunnel/internal/server/utils"
 )
 
-func HandleAuthMessage(msg []byte, tunnel *models.ServerTunnelConn, connections map[string]*models.ServerTunnelConn, connMu *sync.Mutex, authenticating map[string]*models.ServerTunnelConn, authMu *sync.Mutex) (bool, error) {
+func HandleAuthMessage(msg []byte, tunnel *models.ServerTunnelConn, connections map[string]*models.ServerTunnelConn, connMu *sync.Mutex, authenticating map[string]*models.ServerTunnelConn, authMu *sync.Mutex, config *models.ServerConfig) (bool, error) {
 	var socketMsg protocol.SocketMessage
 	if err := protocol.DeserializeMessage(msg, &socketMsg); err != nil {
-		logger.Errorf("Failed to deserialize auth message: %v", err)
+		tunnel.Log.Errorf("Failed to deserialize auth message: %v", err)
 		return false, err
 	}
 
@@ -26,6 +31,12 @@ func HandleAuthMessage(msg []byte, tunnel *models.ServerTunnelConn, connections
 		if err := protocol.DeserializeMessage(socketMsg.Payload, &authRequest); err != nil {
 			return false, err
 		}
+		if authRequest.ProtocolVersion < protocol.Version {
+			err := fmt.Errorf("the client speaks protocol version %d, this server needs %d: upgrade gtc", max(authRequest.ProtocolVersion, 1), protocol.Version)
+			tunnel.Log.Warn(err)
+			RejectAuth(tunnel, err.Error(), authenticating, authMu)
+			return false, err
+		}
 
 		baseURL := authRequest.BaseURL
 		if len(baseURL) > 0 && baseURL[0] == '/' {
@@ -37,48 +48,175 @@ func HandleAuthMessage(msg []byte, tunnel *models.ServerTunnelConn, connections
 		}
 
 		if err := utils.ValidateBaseURLAvailability(baseURL, connections, connMu); err != nil {
-			logger.Errorf("BaseURL validation failed: %v", err)
+			tunnel.Log.Errorf("BaseURL validation failed: %v", err)
 			HandleAuthFailure(tunnel, authenticating, authMu)
 			return false, err
 		}
 
 		tunnel.BaseURL = baseURL
+		tunnel.Log = tunnel.Log.WithField("base_url", baseURL)
+		tunnel.ResponseTimeout = config.Timeouts.TunnelResponseTimeout(time.Duration(authRequest.ResponseTimeoutMs) * time.Millisecond)
+		tunnel.ForwardedHeaders = !authRequest.DisableForwardedHeaders
 
-		success, err := AuthenticateTunnel(&authRequest)
+		device, err := AuthenticateTunnel(&authRequest)
 		if err != nil {
-			logger.Errorf("Authentication failed: %v", err)
+			tunnel.Log.Errorf("Authentication failed: %v", err)
 			HandleAuthFailure(tunnel, authenticating, authMu)
 			return false, err
 		}
-		if success {
-			HandleAuthSuccess(tunnel, connections, connMu, authenticating, authMu)
-			return true, nil
+		if device != nil {
+			tunnel.DeviceID = device.ID
+			tunnel.Log = tunnel.Log.WithField("device", device.Name)
 		}
-		HandleAuthFailure(tunnel, authenticating, authMu)
-		return false, fmt.Errorf("authentication failed")
+
+		tunnel.Access, err = NewAccessPolicy(&authRequest, models.TokenUsageKey(tunnel.DeviceID), config.TokenPolicy(tunnel.DeviceID))
+		if err == nil && tunnel.Access.OIDC != nil && !config.OIDC.Enabled() {
+			err = fmt.Errorf("OIDC sign-in is not configured on this server")
+		}
+		if err != nil {
+			tunnel.Log.Errorf("Invalid access policy: %v", err)
+			RejectAuth(tunnel, err.Error(), authenticating, authMu)
+			return false, err
+		}
+		if n := len(tunnel.Access.BasicAuth); n > 0 {
+			tunnel.Log.Infof("Basic auth enabled for %d user(s)", n)
+		}
+		for _, filter := range tunnel.Access.IPFilters {
+			tunnel.Log.Infof("IP filter from the %s: %d allowed, %d denied network(s)", filter.Source, len(filter.Allow), len(filter.Deny))
+		}
+		for _, limiter := range tunnel.Access.RateLimits {
+			tunnel.Log.Infof("Rate limit from the %s: %s", limiter.Source, limiter.Limit)
+		}
+		if rule := tunnel.Access.OIDC; rule != nil {
+			tunnel.Log.Infof("OIDC sign-in required, email domains %v, groups %v", rule.EmailDomains, rule.Groups)
+		}
+		corsPolicy := config.CORS.Policy()
+		if authRequest.CORS != nil {
+			corsPolicy = *authRequest.CORS
+		}
+		tunnel.CORS, err = cors.New(corsPolicy)
+		if err != nil {
+			tunnel.Log.Errorf("Invalid CORS policy: %v", err)
+			RejectAuth(tunnel, "invalid CORS policy: "+err.Error(), authenticating, authMu)
+			return false, err
+		}
+		switch tunnel.CORS.Mode {
+		case protocol.CORSModePolicy:
+			tunnel.Log.Infof("CORS handled by the server for origins %v", corsPolicy.AllowOrigins)
+		case protocol.CORSModeOff:
+			tunnel.Log.Info("Cross-origin requests refused")
+		}
+		tunnel.Headers, err = compileHeaderRules(&authRequest, config.TokenPolicy(tunnel.DeviceID))
+		if err != nil {
+			tunnel.Log.Errorf("Invalid header rules: %v", err)
+			RejectAuth(tunnel, err.Error(), authenticating, authMu)
+			return false, err
+		}
+		if requests, responses := tunnel.Headers.Count(); requests+responses > 0 {
+			tunnel.Log.Infof("Header rules: %d for requests, %d for responses", requests, responses)
+		}
+		if authRequest.Paths != nil {
+			tunnel.Paths, err = paths.New(*authRequest.Paths)
+			if err != nil {
+				tunnel.Log.Errorf("Invalid path options: %v", err)
+				RejectAuth(tunnel, "invalid path options: "+err.Error(), authenticating, authMu)
+				return false, err
+			}
+			tunnel.Log.Infof("Path mode %s, %d rewrite rule(s)", tunnel.Paths.Mode, len(authRequest.Paths.Rewrites))
+		}
+		tunnel.Limits = config.Limits.Override(config.TokenPolicy(tunnel.DeviceID).Limits).WithDefaults()
+		tunnel.Conn.SetReadLimit(tunnel.Limits.MaxMessageSize)
+		if config.HAR.Dir != "" && (config.HAR.All || authRequest.RecordHAR) {
+			tunnel.HAR, err = utils.OpenTunnelHAR(config.HAR, baseURL)
+			if err != nil {
+				tunnel.Log.Errorf("Failed to start HAR recording: %v", err)
+			} else {
+				tunnel.Log.Infof("Recording traffic to %s", tunnel.HAR.Path())
+			}
+		} else if authRequest.RecordHAR {
+			tunnel.Log.Warn("Tunnel asked for HAR recording but no HAR directory is configured")
+		}
+		HandleAuthSuccess(tunnel, connections, connMu, authenticating, authMu)
+		return true, nil
 	default:
-		logger.Warnf("Unknown auth message type: %v", socketMsg.Type)
+		tunnel.Log.Warnf("Unknown auth message type: %v", socketMsg.Type)
 	}
 	return false, fmt.Errorf("unknown auth message type: %v", socketMsg.Type)
 }
 
-func AuthenticateTunnel(authReq *protocol.AuthRequestMessage) (bool, error) {
+// compileHeaderRules returns the header rules of a tunnel followed by the ones of its token policy
+func compileHeaderRules(authRequest *protocol.AuthRequestMessage, tokenPolicy models.TokenPolicy) (headers.Rules, error) {
+	var requested headers.Rules
+	if authRequest.Headers != nil {
+		var err error
+		if requested, err = headers.Compile(*authRequest.Headers); err != nil {
+			return headers.Rules{}, fmt.Errorf("invalid tunnel %w", err)
+		}
+	}
+	enforced, err := headers.Compile(tokenPolicy.Headers.Rules())
+	if err != nil {
+		return headers.Rules{}, fmt.Errorf("invalid token policy %w", err)
+	}
+	return requested.Then(enforced), nil
+}
+
+// AuthenticateTunnel checks the token of a tunnel against the shared access token and the
+// device tokens issued by gtc login. It returns the device for a device token, nil otherwise.
+func AuthenticateTunnel(authReq *protocol.AuthRequestMessage) (*models.Device, error) {
+	if strings.HasPrefix(authReq.AccessToken, models.DeviceTokenPrefix) {
+		device, err := repositories.NewDeviceRepo().Authenticate(authReq.AccessToken)
+		if err == nil {
+			return device, nil
+		}
+		if !errors.Is(err, repositories.ErrInvalidDeviceToken) {
+			return nil, fmt.Errorf("failed to check device token: %w", err)
+		}
+	}
+
 	configRepo := repositories.NewServerConfigRepo()
 
 	config, err := configRepo.Load()
 	if err != nil {
-		return false, fmt.Errorf("failed to load config: %w", err)
+		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
-	logger.Debugf("SERVER Loaded config: %+v", config)
-
 	if authReq.AccessToken != config.AccessToken {
-		return false, fmt.Errorf("invalid access_token")
+		return nil, fmt.Errorf("invalid access_token")
+	}
+
+	return nil, nil
+}
+
+// CloseRevokedTunnels closes the open tunnels of devices that were revoked since they connected
+func CloseRevokedTunnels(devices repositories.DeviceRepository, connections map[string]*models.ServerTunnelConn, connMu *sync.Mutex) {
+	store, err := devices.List()
+	if err != nil {
+		if !errors.Is(err, repositories.ErrDevicesUnavailable) {
+			logger.Errorf("Failed to check revoked devices: %v", err)
+		}
+		return
+	}
+
+	revoked := make(map[string]bool)
+	for _, device := range store.Devices {
+		if device.Revoked() {
+			revoked[device.ID] = true
+		}
+	}
+	if len(revoked) == 0 {
+		return
 	}
 
-	return true, nil
+	connMu.Lock()
+	defer connMu.Unlock()
+	for _, tunnel := range connections {
+		if tunnel.DeviceID != "" && revoked[tunnel.DeviceID] {
+			tunnel.Log.Warn("Closing tunnel, its device was revoked")
+			tunnel.Conn.Close()
+		}
+	}
 }
 func HandleAuthSuccess(tunnel *models.ServerTunnelConn, connections map[string]*models.ServerTunnelConn, connMu *sync.Mutex, authenticating map[string]*models.ServerTunnelConn, authMu *sync.Mutex) {
-	logger.Infof("[%s] Authentication successful", tunnel.ID)
+	tunnel.Log.Info("Authentication successful")
 
 	connMu.Lock()
 	connections[tunnel.ID] = tunnel
@@ -89,15 +227,21 @@ func HandleAuthSuccess(tunnel *models.ServerTunnelConn, connections map[string]*
 	authMu.Unlock()
 
 	authResponse := &protocol.AuthResponseMessage{
-		ID:      &tunnel.ID,
-		Success: true,
-		Message: "Authentication successful",
-		BaseURL: tunnel.BaseURL,
+		ProtocolVersion:   protocol.Version,
+		ID:                &tunnel.ID,
+		Success:           true,
+		Message:           "Authentication successful",
+		BaseURL:           tunnel.BaseURL,
+		ResponseTimeoutMs: tunnel.ResponseTimeout.Milliseconds(),
+		RecordingHAR:      tunnel.HAR != nil,
+		MaxRequestBody:    tunnel.Limits.MaxRequestBody,
+		MaxResponseBody:   tunnel.Limits.MaxResponseBody,
+		MaxMessageSize:    tunnel.Limits.MaxMessageSize,
 	}
 
 	serializedPayload, err := protocol.SerializeMessage(authResponse)
 	if err != nil {
-		logger.Errorf("[%s] Failed to serialize auth response: %v", tunnel.ID, err)
+		tunnel.Log.Errorf("Failed to serialize auth response: %v", err)
 		return
 	}
 
@@ -106,21 +250,37 @@ func HandleAuthSuccess(tunnel *models.ServerTunnelConn, connections map[string]*
 		Payload: serializedPayload,
 	}
 
-	if err := tunnel.Conn.WriteJSON(socketMsg); err != nil {
-		logger.Errorf("[%s] Failed to send auth success response: %v", tunnel.ID, err)
+	if err := tunnel.WriteJSON(socketMsg); err != nil {
+		tunnel.Log.Errorf("Failed to send auth success response: %v", err)
 	}
 }
 
+// RejectAuth tells the client why its tunnel was refused before closing the connection
+func RejectAuth(tunnel *models.ServerTunnelConn, message string, authenticating map[string]*models.ServerTunnelConn, authMu *sync.Mutex) {
+	socketMsg, err := protocol.NewSocketMessage("", protocol.MessageTypeAuthResponse, &protocol.AuthResponseMessage{Success: false, Message: message})
+	if err == nil {
+		err = tunnel.WriteJSON(socketMsg)
+	}
+	if err != nil {
+		tunnel.Log.Errorf("Failed to send auth failure response: %v", err)
+	}
+	HandleAuthFailure(tunnel, authenticating, authMu)
+}
+
 func HandleAuthFailure(tunnel *models.ServerTunnelConn, authenticating map[string]*models.ServerTunnelConn, authMu *sync.Mutex) {
 	authMu.Lock()
 	delete(authenticating, tunnel.ID)
 	authMu.Unlock()
 
 	tunnel.Conn.Close()
-	logger.Warnf("[%s] Connection closed due to authentication failure", tunnel.ID)
+	tunnel.Log.Warn("Connection closed due to authentication failure")
 }
 
-func HandleWSAuth(tunnel *models.ServerTunnelConn, r *http.Request, authenticating map[string]*models.ServerTunnelConn, authMu *sync.Mutex, connections map[string]*models.ServerTunnelConn, connMu *sync.Mutex) (bool, error) {
+func HandleWSAuth(tunnel *models.ServerTunnelConn, r *http.Request, authenticating map[string]*models.ServerTunnelConn, authMu *sync.Mutex, connections map[string]*models.ServerTunnelConn, connMu *sync.Mutex, config *models.ServerConfig) (bool, error) {
+	timeouts := config.Timeouts.WithDefaults()
+
+	tunnel.Conn.SetReadLimit(models.MaxAuthMessageSize)
+
 	done := make(chan struct{})
 	var msg []byte
 	var readErr error
@@ -138,22 +298,23 @@ func HandleWSAuth(tunnel *models.ServerTunnelConn, r *http.Request, authenticati
 	select {
 	case <-done:
 		if readErr != nil {
-			logger.Errorf("[%s] Read error during auth: %v", tunnel.ID, readErr)
+			tunnel.Log.Errorf("Read error during auth: %v", readErr)
 			HandleAuthFailure(tunnel, authenticating, authMu)
 			return false, readErr
 		}
 
-		logger.Debugf("[%s] Received auth message: %s", tunnel.ID, msg)
-		success, err := HandleAuthMessage(msg, tunnel, connections, connMu, authenticating, authMu)
+		// the message carries the access token and basic auth passwords, only its size is logged
+		tunnel.Log.Debugf("Received auth message of %d bytes", len(msg))
+		success, err := HandleAuthMessage(msg, tunnel, connections, connMu, authenticating, authMu, config)
 		if err != nil {
-			logger.Errorf("[%s] Error handling auth message: %v", tunnel.ID, err)
+			tunnel.Log.Errorf("Error handling auth message: %v", err)
 			return false, err
 		}
 
 		return success, nil
 
-	case <-time.After(10 * time.Second):
-		logger.Warnf("[%s] Authentication timeout - no message received in 10 seconds", tunnel.ID)
+	case <-time.After(timeouts.Auth):
+		tunnel.Log.Warnf("Authentication timeout - no message received in %s", timeouts.Auth)
 		HandleAuthFailure(tunnel, authenticating, authMu)
 		return false, fmt.Errorf("authentication timeout")
 	}
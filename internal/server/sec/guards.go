@@ -0,0 +1,222 @@
+package sec
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/cors"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/errorpages"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/ratelimit"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// BasicAuthGuard asks for the credentials of tunnels protected with gtc connect --basic-auth.
+// The Authorization header is removed once accepted so the local service never sees it.
+// Passwords that need a bcrypt check take a token from the bucket of the client IP, so
+// visitors cannot make the server spend its CPU on guesses.
+func BasicAuthGuard(pages *errorpages.Renderer, trusted []*net.IPNet, perIP *ratelimit.Keyed) func(http.ResponseWriter, *http.Request, *models.ServerTunnelConn, string) bool {
+	return func(w http.ResponseWriter, r *http.Request, tunnel *models.ServerTunnelConn, requestID string) bool {
+		credentials := tunnel.Access.BasicAuth
+		if len(credentials) == 0 {
+			return true
+		}
+
+		username, password, ok := r.BasicAuth()
+		if ok {
+			for _, credential := range credentials {
+				if credential.Recent(username, password) {
+					r.Header.Del("Authorization")
+					return true
+				}
+			}
+			clientIP := utils.ClientIP(r, trusted)
+			if allowed, wait := perIP.Take(clientIP); !allowed {
+				tunnel.Log.WithFields(logrus.Fields{"request_id": requestID, "client_ip": clientIP}).Info("Rejected basic auth attempt over the per-IP limit")
+				TooManyRequests(w, r, pages, wait, requestID)
+				return false
+			}
+			for _, credential := range credentials {
+				if credential.Matches(username, password) {
+					r.Header.Del("Authorization")
+					return true
+				}
+			}
+			tunnel.Log.WithField("request_id", requestID).Infof("Rejected request with invalid basic auth credentials for %q", username)
+		}
+
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="gTunnel /%s", charset="UTF-8"`, tunnel.BaseURL))
+		pages.Error(w, r, http.StatusUnauthorized, errorpages.CodeAuthRequired, "", requestID)
+		return false
+	}
+}
+
+// RateLimitGuard answers 429 to clients over the per-IP limit and to requests over the limits of the tunnel
+func RateLimitGuard(pages *errorpages.Renderer, trusted []*net.IPNet, perIP *ratelimit.Keyed) func(http.ResponseWriter, *http.Request, *models.ServerTunnelConn, string) bool {
+	return func(w http.ResponseWriter, r *http.Request, tunnel *models.ServerTunnelConn, requestID string) bool {
+		clientIP := utils.ClientIP(r, trusted)
+		if ok, wait := perIP.Take(clientIP); !ok {
+			tunnel.Log.WithFields(logrus.Fields{"request_id": requestID, "client_ip": clientIP}).Info("Rejected request over the per-IP rate limit")
+			TooManyRequests(w, r, pages, wait, requestID)
+			return false
+		}
+		limiters := tunnel.Access.RateLimits
+		buckets := make([]*ratelimit.Bucket, len(limiters))
+		for i, limiter := range limiters {
+			buckets[i] = limiter.Bucket
+		}
+		if ok, i, wait := ratelimit.TakeAll(buckets); !ok {
+			tunnel.Log.WithFields(logrus.Fields{"request_id": requestID, "client_ip": clientIP}).Infof("Rejected request over the %s rate limit", limiters[i].Source)
+			TooManyRequests(w, r, pages, wait, requestID)
+			return false
+		}
+		return true
+	}
+}
+
+// TooManyRequests writes a 429 telling the client when to retry
+func TooManyRequests(w http.ResponseWriter, r *http.Request, pages *errorpages.Renderer, wait time.Duration, requestID string) {
+	w.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+	pages.Error(w, r, http.StatusTooManyRequests, errorpages.CodeRateLimited, "", requestID)
+}
+
+// IPFilterGuard rejects clients outside the allowed networks of a tunnel or inside its denied ones.
+// The client IP is taken from X-Forwarded-For only when the request comes from a trusted proxy.
+func IPFilterGuard(pages *errorpages.Renderer, trusted []*net.IPNet) func(http.ResponseWriter, *http.Request, *models.ServerTunnelConn, string) bool {
+	return func(w http.ResponseWriter, r *http.Request, tunnel *models.ServerTunnelConn, requestID string) bool {
+		filters := tunnel.Access.IPFilters
+		if len(filters) == 0 {
+			return true
+		}
+
+		clientIP := utils.ClientIP(r, trusted)
+		ip := net.ParseIP(clientIP)
+		for _, filter := range filters {
+			if !filter.Allows(ip) {
+				tunnel.Log.WithFields(logrus.Fields{"request_id": requestID, "client_ip": clientIP}).Infof("Rejected request by the %s IP filter", filter.Source)
+				pages.Error(w, r, http.StatusForbidden, errorpages.CodeIPNotAllowed, "", requestID)
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// CORSGuard answers the preflight requests of tunnels whose CORS is handled by the server and
+// sets the CORS headers of their responses, so error pages can be read cross-origin too.
+// Preflight requests of tunnels in passthrough mode go on to the local app.
+func CORSGuard(pages *errorpages.Renderer) func(http.ResponseWriter, *http.Request, *models.ServerTunnelConn, string) bool {
+	return func(w http.ResponseWriter, r *http.Request, tunnel *models.ServerTunnelConn, requestID string) bool {
+		policy := tunnel.CORS
+		if policy.Passthrough() {
+			return true
+		}
+
+		origin := r.Header.Get("Origin")
+		if cors.IsPreflight(r) {
+			if !policy.Preflight(w.Header(), r) {
+				tunnel.Log.WithField("request_id", requestID).Infof("Rejected CORS preflight from %s", origin)
+				pages.Error(w, r, http.StatusForbidden, errorpages.CodeCORSRejected, "", requestID)
+				return false
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return false
+		}
+		policy.Apply(w.Header(), origin)
+		return true
+	}
+}
+
+// tokenBuckets are the request buckets of the token policies, keyed by token and limit,
+// so all the tunnels of a token share one and reconnecting does not refill it
+var (
+	tokenBuckets   = make(map[string]*ratelimit.Bucket)
+	tokenBucketsMu sync.Mutex
+)
+
+func tokenBucket(tokenKey string, limit ratelimit.Limit) *ratelimit.Bucket {
+	key := tokenKey + " " + limit.String()
+	tokenBucketsMu.Lock()
+	defer tokenBucketsMu.Unlock()
+	bucket, ok := tokenBuckets[key]
+	if !ok {
+		bucket = ratelimit.NewBucket(limit)
+		tokenBuckets[key] = bucket
+	}
+	return bucket
+}
+
+// NewAccessPolicy builds the access policy of a tunnel from its handshake and the policy of its
+// token, keyed like models.TokenUsageKey
+func NewAccessPolicy(authRequest *protocol.AuthRequestMessage, tokenKey string, tokenPolicy models.TokenPolicy) (models.AccessPolicy, error) {
+	var policy models.AccessPolicy
+
+	for _, source := range []struct {
+		name        string
+		allow, deny []string
+	}{
+		{"token policy", tokenPolicy.AllowCIDRs, tokenPolicy.DenyCIDRs},
+		{"tunnel", authRequest.AllowCIDRs, authRequest.DenyCIDRs},
+	} {
+		if len(source.allow) == 0 && len(source.deny) == 0 {
+			continue
+		}
+		allow, err := utils.ParseNetworks(source.allow)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s allow list: %w", source.name, err)
+		}
+		deny, err := utils.ParseNetworks(source.deny)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s deny list: %w", source.name, err)
+		}
+		policy.IPFilters = append(policy.IPFilters, models.IPFilter{Source: source.name, Allow: allow, Deny: deny})
+	}
+
+	for _, credential := range authRequest.BasicAuth {
+		if credential.Username == "" || credential.Password == "" {
+			return policy, fmt.Errorf("basic auth credentials need a username and a password")
+		}
+		hashed, err := models.NewHashedCredential(credential.Username, credential.Password)
+		if err != nil {
+			return policy, fmt.Errorf("failed to hash basic auth credentials: %w", err)
+		}
+		policy.BasicAuth = append(policy.BasicAuth, hashed)
+	}
+
+	requested := protocol.RateLimit{}
+	if authRequest.RateLimit != nil {
+		requested = *authRequest.RateLimit
+	}
+	for _, source := range []struct {
+		name   string
+		rate   string
+		burst  int
+		shared bool
+	}{
+		{"token policy", tokenPolicy.RateLimit.Rate, tokenPolicy.RateLimit.Burst, true},
+		{"tunnel", requested.Rate, requested.Burst, false},
+	} {
+		limit, err := ratelimit.Parse(source.rate, source.burst)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s rate limit: %w", source.name, err)
+		}
+		if !limit.Enabled() {
+			continue
+		}
+		bucket := ratelimit.NewBucket(limit)
+		if source.shared {
+			bucket = tokenBucket(tokenKey, limit)
+		}
+		policy.RateLimits = append(policy.RateLimits, models.RateLimiter{Source: source.name, Limit: limit, Bucket: bucket})
+	}
+
+	if authRequest.OIDC != nil {
+		policy.OIDC = &models.OIDCRule{EmailDomains: authRequest.OIDC.EmailDomains, Groups: authRequest.OIDC.Groups}
+	}
+	return policy, nil
+}
@@ -0,0 +1,288 @@
+package sec
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/errorpages"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/ratelimit"
+	"github.com/sirupsen/logrus"
+)
+
+func testPages(t *testing.T) *errorpages.Renderer {
+	t.Helper()
+	pages, err := errorpages.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pages
+}
+
+func testTunnel(t *testing.T, authRequest protocol.AuthRequestMessage, tokenKey string, tokenPolicy models.TokenPolicy) *models.ServerTunnelConn {
+	t.Helper()
+	access, err := NewAccessPolicy(&authRequest, tokenKey, tokenPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return &models.ServerTunnelConn{BaseURL: "app", Access: access, Log: logrus.NewEntry(log)}
+}
+
+func TestNewAccessPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		authRequest protocol.AuthRequestMessage
+		tokenPolicy models.TokenPolicy
+		wantErr     bool
+		wantFilters int
+		wantLimits  int
+	}{
+		{name: "empty"},
+		{
+			name:        "filters from both sources",
+			authRequest: protocol.AuthRequestMessage{AllowCIDRs: []string{"10.0.0.0/8"}},
+			tokenPolicy: models.TokenPolicy{DenyCIDRs: []string{"192.0.2.1"}},
+			wantFilters: 2,
+		},
+		{
+			name:        "limits from both sources",
+			authRequest: protocol.AuthRequestMessage{RateLimit: &protocol.RateLimit{Rate: "10/s"}},
+			tokenPolicy: models.TokenPolicy{RateLimit: models.RateLimit{Rate: "100/m"}},
+			wantLimits:  2,
+		},
+		{name: "invalid CIDR", authRequest: protocol.AuthRequestMessage{DenyCIDRs: []string{"nope"}}, wantErr: true},
+		{name: "invalid rate", authRequest: protocol.AuthRequestMessage{RateLimit: &protocol.RateLimit{Rate: "fast"}}, wantErr: true},
+		{name: "basic auth without password", authRequest: protocol.AuthRequestMessage{BasicAuth: []protocol.BasicAuthCredential{{Username: "alice"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewAccessPolicy(&tt.authRequest, "token-"+tt.name, tt.tokenPolicy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewAccessPolicy() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(policy.IPFilters) != tt.wantFilters || len(policy.RateLimits) != tt.wantLimits {
+				t.Errorf("got %d filters and %d limits, want %d and %d", len(policy.IPFilters), len(policy.RateLimits), tt.wantFilters, tt.wantLimits)
+			}
+		})
+	}
+}
+
+func TestTokenPolicyBucketIsShared(t *testing.T) {
+	tokenPolicy := models.TokenPolicy{RateLimit: models.RateLimit{Rate: "1/h", Burst: 1}}
+	first := testTunnel(t, protocol.AuthRequestMessage{}, "shared-token", tokenPolicy)
+	second := testTunnel(t, protocol.AuthRequestMessage{}, "shared-token", tokenPolicy)
+	other := testTunnel(t, protocol.AuthRequestMessage{}, "other-token", tokenPolicy)
+
+	guard := RateLimitGuard(testPages(t), nil, nil)
+	for _, step := range []struct {
+		tunnel *models.ServerTunnelConn
+		want   bool
+	}{
+		{first, true},
+		{second, false}, // same token, the burst of 1 is spent
+		{other, true},
+	} {
+		w := httptest.NewRecorder()
+		if got := guard(w, httptest.NewRequest(http.MethodGet, "/", nil), step.tunnel, "req"); got != step.want {
+			t.Errorf("guard() = %v, want %v (status %d)", got, step.want, w.Code)
+		}
+	}
+}
+
+func TestRateLimitGuard(t *testing.T) {
+	pages := testPages(t)
+	tests := []struct {
+		name     string
+		perIP    ratelimit.Limit
+		tunnel   string
+		requests int
+		wantLast int
+	}{
+		{"no limits", ratelimit.Limit{}, "", 5, http.StatusOK},
+		{"per IP", ratelimit.Limit{Requests: 1, Per: time.Hour, Burst: 2}, "", 3, http.StatusTooManyRequests},
+		{"tunnel", ratelimit.Limit{}, "1/h", 2, http.StatusTooManyRequests},
+		{"within both", ratelimit.Limit{Requests: 1, Per: time.Hour, Burst: 3}, "3/h", 3, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tunnel := testTunnel(t, protocol.AuthRequestMessage{RateLimit: &protocol.RateLimit{Rate: tt.tunnel}}, "token-"+tt.name, models.TokenPolicy{})
+			guard := RateLimitGuard(pages, nil, ratelimit.NewKeyed(tt.perIP))
+
+			status := http.StatusOK
+			for i := 0; i < tt.requests; i++ {
+				w := httptest.NewRecorder()
+				if !guard(w, httptest.NewRequest(http.MethodGet, "/", nil), tunnel, "req") {
+					status = w.Code
+					if w.Header().Get("Retry-After") == "" {
+						t.Error("429 without Retry-After")
+					}
+				}
+			}
+			if status != tt.wantLast {
+				t.Errorf("last status = %d, want %d", status, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestIPFilterGuard(t *testing.T) {
+	pages := testPages(t)
+	tests := []struct {
+		name        string
+		authRequest protocol.AuthRequestMessage
+		tokenPolicy models.TokenPolicy
+		remoteAddr  string
+		want        bool
+	}{
+		{"no filter", protocol.AuthRequestMessage{}, models.TokenPolicy{}, "203.0.113.7:1", true},
+		{"allowed", protocol.AuthRequestMessage{AllowCIDRs: []string{"203.0.113.0/24"}}, models.TokenPolicy{}, "203.0.113.7:1", true},
+		{"not allowed", protocol.AuthRequestMessage{AllowCIDRs: []string{"10.0.0.0/8"}}, models.TokenPolicy{}, "203.0.113.7:1", false},
+		{"denied", protocol.AuthRequestMessage{DenyCIDRs: []string{"203.0.113.7"}}, models.TokenPolicy{}, "203.0.113.7:1", false},
+		{"IPv6 allowed", protocol.AuthRequestMessage{AllowCIDRs: []string{"2001:db8::/32"}}, models.TokenPolicy{}, "[2001:db8::7]:1", true},
+		{"tunnel allows but token policy denies", protocol.AuthRequestMessage{AllowCIDRs: []string{"203.0.113.0/24"}}, models.TokenPolicy{DenyCIDRs: []string{"203.0.113.7"}}, "203.0.113.7:1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tunnel := testTunnel(t, tt.authRequest, "token", tt.tokenPolicy)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			if got := IPFilterGuard(pages, nil)(w, r, tunnel, "req"); got != tt.want {
+				t.Errorf("guard() = %v, want %v", got, tt.want)
+			}
+			if !tt.want && w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", w.Code)
+			}
+		})
+	}
+}
+
+func TestBasicAuthGuard(t *testing.T) {
+	pages := testPages(t)
+	tunnel := testTunnel(t, protocol.AuthRequestMessage{
+		BasicAuth: []protocol.BasicAuthCredential{{Username: "alice", Password: "s3cret"}, {Username: "bob", Password: "hunter2"}},
+	}, "token", models.TokenPolicy{})
+
+	tests := []struct {
+		name       string
+		user, pass string
+		noAuth     bool
+		want       int
+	}{
+		{name: "no credentials", noAuth: true, want: http.StatusUnauthorized},
+		{name: "wrong password", user: "alice", pass: "nope", want: http.StatusUnauthorized},
+		{name: "password of another user", user: "alice", pass: "hunter2", want: http.StatusUnauthorized},
+		{name: "unknown user", user: "mallory", pass: "s3cret", want: http.StatusUnauthorized},
+		{name: "first user", user: "alice", pass: "s3cret", want: http.StatusOK},
+		{name: "second user", user: "bob", pass: "hunter2", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if !tt.noAuth {
+				r.SetBasicAuth(tt.user, tt.pass)
+			}
+			w := httptest.NewRecorder()
+			status := http.StatusOK
+			if !BasicAuthGuard(pages, nil, nil)(w, r, tunnel, "req") {
+				status = w.Code
+			}
+			if status != tt.want {
+				t.Fatalf("status = %d, want %d", status, tt.want)
+			}
+			if status == http.StatusOK && r.Header.Get("Authorization") != "" {
+				t.Error("the Authorization header is forwarded")
+			}
+			if status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestBasicAuthGuardPerIPLimit(t *testing.T) {
+	pages := testPages(t)
+	tunnel := testTunnel(t, protocol.AuthRequestMessage{
+		BasicAuth: []protocol.BasicAuthCredential{{Username: "alice", Password: "s3cret"}},
+	}, "token", models.TokenPolicy{})
+	guard := BasicAuthGuard(pages, nil, ratelimit.NewKeyed(ratelimit.Limit{Requests: 1, Per: time.Hour, Burst: 2}))
+
+	attempt := func(remoteAddr, pass string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.SetBasicAuth("alice", pass)
+		w := httptest.NewRecorder()
+		if guard(w, r, tunnel, "req") {
+			return http.StatusOK
+		}
+		return w.Code
+	}
+
+	steps := []struct {
+		name       string
+		remoteAddr string
+		pass       string
+		want       int
+	}{
+		{"checked password", "192.0.2.1:1", "s3cret", http.StatusOK},
+		{"recent password skips the limit", "192.0.2.1:1", "s3cret", http.StatusOK},
+		{"guess", "192.0.2.1:1", "guess", http.StatusUnauthorized},
+		{"guess over the limit", "192.0.2.1:1", "guess", http.StatusTooManyRequests},
+		{"recent password still accepted", "192.0.2.1:1", "s3cret", http.StatusOK},
+		{"other IP has its own bucket", "192.0.2.2:1", "guess", http.StatusUnauthorized},
+	}
+	for _, step := range steps {
+		if got := attempt(step.remoteAddr, step.pass); got != step.want {
+			t.Errorf("%s: status = %d, want %d", step.name, got, step.want)
+		}
+	}
+}
+
+func TestHashedCredentialCache(t *testing.T) {
+	credential, err := models.NewHashedCredential("alice", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if credential.Recent("alice", "s3cret") {
+		t.Fatal("Recent before any check")
+	}
+	if credential.Matches("alice", "wrong") {
+		t.Fatal("Matches accepted a wrong password")
+	}
+	if credential.Recent("alice", "wrong") {
+		t.Error("a rejected password is remembered")
+	}
+	if !credential.Matches("alice", "s3cret") {
+		t.Fatal("Matches rejected the password")
+	}
+
+	tests := []struct {
+		user, pass string
+		want       bool
+	}{
+		{"alice", "s3cret", true},
+		{"alice", "s3cret ", false},
+		{"Alice", "s3cret", false},
+		{"bob", "s3cret", false},
+	}
+	for _, tt := range tests {
+		if got := credential.Recent(tt.user, tt.pass); got != tt.want {
+			t.Errorf("Recent(%q, %q) = %v, want %v", tt.user, tt.pass, got, tt.want)
+		}
+	}
+
+	other, err := models.NewHashedCredential("alice", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Recent("alice", "s3cret") {
+		t.Error("credentials share their cache")
+	}
+}
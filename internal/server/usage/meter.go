@@ -0,0 +1,248 @@
+package usage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/logger"
+	"github.com/B-AJ-Amar/gTunnel/internal/protocol"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/errorpages"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/ratelimit"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/repositories"
+	"github.com/sirupsen/logrus"
+)
+
+// Meter counts the requests and bytes of every token and tunnel, enforces their quotas
+// and saves the counts through the usage repository
+type Meter struct {
+	repo   repositories.UsageRepository
+	config *models.ServerConfig
+
+	mu        sync.Mutex
+	store     *models.UsageStore
+	dirty     bool
+	throttles map[string]*ratelimit.Bucket
+}
+
+// scope is the usage and quota of a token or of a tunnel
+type scope struct {
+	name  string // "token" or "tunnel"
+	key   string
+	quota models.Quota
+	usage *models.UsageRecord
+}
+
+// NewMeter loads the saved usage. A corrupt usage file is renamed and counting starts from
+// zero, any other failure to read it is returned so the server does not overwrite it.
+func NewMeter(repo repositories.UsageRepository, config *models.ServerConfig) (*Meter, error) {
+	store, err := repo.Load()
+	switch {
+	case err == nil:
+	case errors.Is(err, repositories.ErrUsageUnavailable):
+		logger.Warn("Usage is kept in memory only, quotas restart from zero with the server")
+	case errors.Is(err, repositories.ErrUsageCorrupt):
+		aside, asideErr := repo.SetAside()
+		if asideErr != nil {
+			return nil, fmt.Errorf("%w, and it could not be kept: %v", err, asideErr)
+		}
+		logger.Errorf("Failed to load usage, counting from zero: %v (the file was moved to %s)", err, aside)
+	default:
+		return nil, err
+	}
+	if store.Tokens == nil {
+		store.Tokens = make(map[string]*models.UsageRecord)
+	}
+	if store.Tunnels == nil {
+		store.Tunnels = make(map[string]*models.UsageRecord)
+	}
+	return &Meter{repo: repo, config: config, store: store, throttles: make(map[string]*ratelimit.Bucket)}, nil
+}
+
+// Run saves the usage every interval, it is meant to run in its own goroutine
+func (m *Meter) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := m.Flush(); err != nil && !errors.Is(err, repositories.ErrUsageUnavailable) {
+			logger.Errorf("Failed to save usage: %v", err)
+		}
+	}
+}
+
+// Flush saves the usage if it changed since the last save
+func (m *Meter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneTunnels(time.Now())
+	if !m.dirty {
+		return nil
+	}
+	m.store.UpdatedAt = time.Now()
+	if err := m.repo.Save(m.store); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+// Guard rejects the requests of tokens and tunnels that used up a quota which does not throttle
+func (m *Meter) Guard(pages *errorpages.Renderer) func(http.ResponseWriter, *http.Request, *models.ServerTunnelConn, string) bool {
+	return func(w http.ResponseWriter, r *http.Request, tunnel *models.ServerTunnelConn, requestID string) bool {
+		now := time.Now()
+		m.mu.Lock()
+		var exceeded *models.QuotaItem
+		var scopeName string
+		for _, s := range m.scopes(tunnel) {
+			if s.quota.Throttle > 0 {
+				continue
+			}
+			if item := s.quota.Exceeded(*s.usage, now); item != nil {
+				exceeded, scopeName = item, s.name
+				break
+			}
+		}
+		m.mu.Unlock()
+		if exceeded == nil {
+			return true
+		}
+
+		tunnel.Log.WithFields(logrus.Fields{"request_id": requestID, "quota": exceeded.Name}).Infof("Rejected request, the %s quota is used up", scopeName)
+		w.Header().Set("Retry-After", ratelimit.RetryAfter(exceeded.ResetsAt.Sub(now)))
+		detail := fmt.Sprintf("The %s %s quota is used up until %s.", scopeName, exceeded.Name, exceeded.ResetsAt.Format(time.RFC3339))
+		pages.Error(w, r, http.StatusTooManyRequests, errorpages.CodeQuotaExceeded, detail, requestID)
+		return false
+	}
+}
+
+// Throttle returns the byte buckets of the used up quotas that throttle instead of rejecting
+func (m *Meter) Throttle(tunnel *models.ServerTunnelConn) Throttle {
+	if m == nil {
+		return nil
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var throttle Throttle
+	for _, s := range m.scopes(tunnel) {
+		if s.quota.Throttle <= 0 || s.quota.Exceeded(*s.usage, now) == nil {
+			continue
+		}
+		key := s.name + ":" + s.key
+		bucket, ok := m.throttles[key]
+		if !ok {
+			rate := int(s.quota.Throttle)
+			bucket = ratelimit.NewBucket(ratelimit.Limit{Requests: rate, Per: time.Second, Burst: rate})
+			m.throttles[key] = bucket
+		}
+		throttle = append(throttle, bucket)
+	}
+	return throttle
+}
+
+// Record counts a request and the bytes of its bodies. The tunnel is sent a usage report
+// when one of its quotas crosses UsageWarnFraction or is used up.
+func (m *Meter) Record(tunnel *models.ServerTunnelConn, bytes int64) {
+	if m == nil {
+		return
+	}
+	now := time.Now()
+	m.mu.Lock()
+	crossed := false
+	for _, s := range m.scopes(tunnel) {
+		before := s.quota.Items(*s.usage, now)
+		s.usage.Add(now, 1, bytes)
+		after := s.quota.Items(*s.usage, now)
+		for i := range after {
+			for _, threshold := range []float64{models.UsageWarnFraction, 1} {
+				if before[i].Fraction() < threshold && after[i].Fraction() >= threshold {
+					crossed = true
+				}
+			}
+		}
+	}
+	m.dirty = true
+	m.mu.Unlock()
+
+	if crossed {
+		m.SendReport(tunnel)
+	}
+}
+
+// Report returns the quotas of a tunnel and its token with their usage, nil when there are none
+func (m *Meter) Report(tunnel *models.ServerTunnelConn) *protocol.UsageReport {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := &protocol.UsageReport{}
+	for _, s := range m.scopes(tunnel) {
+		for _, item := range s.quota.Items(*s.usage, now) {
+			report.Quotas = append(report.Quotas, protocol.QuotaStatus{
+				Scope:    s.name,
+				Name:     item.Name,
+				Used:     item.Used,
+				Limit:    item.Limit,
+				ResetsAt: item.ResetsAt,
+				Throttle: s.quota.Throttle,
+			})
+		}
+	}
+	if len(report.Quotas) == 0 {
+		return nil
+	}
+	return report
+}
+
+// SendReport sends the tunnel its usage report, if it has quotas
+func (m *Meter) SendReport(tunnel *models.ServerTunnelConn) {
+	report := m.Report(tunnel)
+	if report == nil {
+		return
+	}
+	msg, err := protocol.NewSocketMessage("", protocol.MessageTypeUsage, report)
+	if err == nil {
+		err = tunnel.WriteJSON(msg)
+	}
+	if err != nil {
+		tunnel.Log.Errorf("Failed to send usage report: %v", err)
+	}
+}
+
+// pruneTunnels drops the usage of the base URLs that saw no traffic this month, their quota
+// counters are back to zero anyway. Tokens are kept, there is one per policy or device. m.mu must be held.
+func (m *Meter) pruneTunnels(now time.Time) {
+	for baseURL, r := range m.store.Tunnels {
+		if r.Stale(now) {
+			delete(m.store.Tunnels, baseURL)
+			delete(m.throttles, "tunnel:"+baseURL)
+			m.dirty = true
+		}
+	}
+}
+
+// scopes returns the token and the tunnel usage with their quotas, m.mu must be held.
+// Tunnel usage is keyed by base URL, so a quota follows the base URL across reconnects.
+func (m *Meter) scopes(tunnel *models.ServerTunnelConn) []scope {
+	policy := m.config.TokenPolicy(tunnel.DeviceID)
+	tokenKey := models.TokenUsageKey(tunnel.DeviceID)
+	tunnelUsage := record(m.store.Tunnels, tunnel.BaseURL)
+	tunnelUsage.Token = tokenKey
+	return []scope{
+		{"token", tokenKey, policy.Quota, record(m.store.Tokens, tokenKey)},
+		{"tunnel", tunnel.BaseURL, policy.TunnelQuota, tunnelUsage},
+	}
+}
+
+func record(records map[string]*models.UsageRecord, key string) *models.UsageRecord {
+	r, ok := records[key]
+	if !ok {
+		r = &models.UsageRecord{}
+		records[key] = r
+	}
+	return r
+}
@@ -0,0 +1,77 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/server/ratelimit"
+)
+
+// throttleChunk is the size of the writes of a throttled response
+const throttleChunk = 16 << 10
+
+// Throttle paces the bytes of the requests over a throttling quota, it is a no-op when empty
+type Throttle []*ratelimit.Bucket
+
+// ErrThrottledTooLong is returned by Wait when the bytes could not go through in time
+var ErrThrottledTooLong = errors.New("the throttled quota delays the request too long")
+
+// Wait blocks until n bytes may go through all the buckets. When ctx is done first, or right
+// away when the wait would be longer than limit (if positive), the bytes are given back and an
+// error returned.
+func (t Throttle) Wait(ctx context.Context, n int, limit time.Duration) error {
+	var wait time.Duration
+	for _, bucket := range t {
+		if w := bucket.Reserve(n); w > wait {
+			wait = w
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+	if limit > 0 && wait > limit {
+		t.refund(n)
+		return ErrThrottledTooLong
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		t.refund(n)
+		return ctx.Err()
+	}
+}
+
+func (t Throttle) refund(n int) {
+	for _, bucket := range t {
+		bucket.Refund(n)
+	}
+}
+
+// Write writes body to w in chunks paced by the throttle, it stops when ctx is done
+func (t Throttle) Write(ctx context.Context, w io.Writer, body []byte) error {
+	if len(t) == 0 {
+		_, err := w.Write(body)
+		return err
+	}
+	for len(body) > 0 {
+		n := min(len(body), throttleChunk)
+		if err := t.Wait(ctx, n, 0); err != nil {
+			return err
+		}
+		if _, err := w.Write(body[:n]); err != nil {
+			return err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		body = body[n:]
+	}
+	return nil
+}
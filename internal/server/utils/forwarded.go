@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// forwardingHeaders are replaced when a request does not come from a trusted proxy
+var forwardingHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Prefix",
+	"X-Real-Ip",
+}
+
+// ParseTrustedProxies parses the IPs and CIDRs of the trusted proxies
+func ParseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	nets, err := ParseNetworks(entries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	return nets, nil
+}
+
+// ParseNetworks parses a list of IPs and CIDRs, a bare IP is treated as a single host network
+func ParseNetworks(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP or CIDR", entry)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			entry = fmt.Sprintf("%s/%d", ip.String(), bits)
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ContainsIP reports whether ip is in one of the networks
+func ContainsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteIP returns the IP of the direct peer of the request
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// IsTrustedProxy reports whether the direct peer of the request is a trusted proxy
+func IsTrustedProxy(r *http.Request, trusted []*net.IPNet) bool {
+	return ContainsIP(trusted, net.ParseIP(RemoteIP(r)))
+}
+
+// ClientIP returns the IP of the public client. X-Forwarded-For is only honoured when the
+// request comes from a trusted proxy, the right-most address that isn't a trusted proxy wins.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote := RemoteIP(r)
+	if !IsTrustedProxy(r, trusted) {
+		return remote
+	}
+
+	chain := splitForwardedFor(r.Header.Values("X-Forwarded-For"))
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			break
+		}
+		if !ContainsIP(trusted, ip) {
+			return ip.String()
+		}
+	}
+	return remote
+}
+
+// RequestScheme returns the scheme the public client used, taken from X-Forwarded-Proto
+// when the request comes from a trusted proxy
+func RequestScheme(r *http.Request, trusted []*net.IPNet) string {
+	if IsTrustedProxy(r, trusted) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// SetForwardedHeaders adds X-Forwarded-For/Host/Proto/Prefix, X-Real-IP and Forwarded to headers
+// (a copy of the request headers). Values set by the public side are replaced unless the request
+// comes from a trusted proxy, in which case this hop is appended to them.
+func SetForwardedHeaders(headers http.Header, r *http.Request, prefix string, trusted []*net.IPNet) {
+	remote := RemoteIP(r)
+	fromProxy := IsTrustedProxy(r, trusted)
+	proto := RequestScheme(r, trusted)
+
+	var chain []string
+	forwarded := ""
+	host := r.Host
+	if fromProxy {
+		chain = splitForwardedFor(headers.Values("X-Forwarded-For"))
+		forwarded = strings.Join(headers.Values("Forwarded"), ", ")
+		if h := headers.Get("X-Forwarded-Host"); h != "" {
+			host = h
+		}
+		if p := headers.Get("X-Forwarded-Prefix"); p != "" {
+			prefix = strings.TrimSuffix(p, "/") + prefix
+		}
+	}
+
+	for _, name := range forwardingHeaders {
+		headers.Del(name)
+	}
+
+	chain = append(chain, remote)
+	element := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedNode(remote), quoteForwarded(r.Host), proto)
+	if forwarded != "" {
+		forwarded += ", " + element
+	} else {
+		forwarded = element
+	}
+
+	headers.Set("X-Forwarded-For", strings.Join(chain, ", "))
+	headers.Set("X-Forwarded-Host", host)
+	headers.Set("X-Forwarded-Proto", proto)
+	if prefix != "" {
+		headers.Set("X-Forwarded-Prefix", prefix)
+	}
+	headers.Set("X-Real-Ip", ClientIP(r, trusted))
+	headers.Set("Forwarded", forwarded)
+}
+
+// StripForwardedHeaders removes the forwarding headers of headers (a copy of the request headers)
+// when the request does not come from a trusted proxy, for tunnels that turned them off
+func StripForwardedHeaders(headers http.Header, r *http.Request, trusted []*net.IPNet) {
+	if IsTrustedProxy(r, trusted) {
+		return
+	}
+	for _, name := range forwardingHeaders {
+		headers.Del(name)
+	}
+}
+
+func splitForwardedFor(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				chain = append(chain, part)
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedNode formats an IP for the Forwarded header, IPv6 addresses must be quoted and bracketed (RFC 7239)
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, ":;,\" ") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
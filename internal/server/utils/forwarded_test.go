@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		entries []string
+		want    []string
+		wantErr bool
+	}{
+		{[]string{"10.0.0.1"}, []string{"10.0.0.1/32"}, false},
+		{[]string{"10.0.0.0/8", " ", "::1"}, []string{"10.0.0.0/8", "::1/128"}, false},
+		{[]string{"2001:db8::/32"}, []string{"2001:db8::/32"}, false},
+		{[]string{"example.com"}, nil, true},
+		{[]string{"10.0.0.0/33"}, nil, true},
+	}
+	for _, tt := range tests {
+		nets, err := ParseNetworks(tt.entries)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseNetworks(%q) error = %v, want error %v", tt.entries, err, tt.wantErr)
+			continue
+		}
+		if len(nets) != len(tt.want) {
+			t.Errorf("ParseNetworks(%q) = %v, want %v", tt.entries, nets, tt.want)
+			continue
+		}
+		for i, n := range nets {
+			if n.String() != tt.want[i] {
+				t.Errorf("ParseNetworks(%q)[%d] = %s, want %s", tt.entries, i, n, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer spoofing", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"right-most untrusted wins", "10.0.0.2:5000", []string{"1.1.1.1, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"split header values", "10.0.0.2:5000", []string{"1.1.1.1", "198.51.100.1"}, "198.51.100.1"},
+		{"garbage stops the walk", "10.0.0.2:5000", []string{"198.51.100.1, nope"}, "10.0.0.2"},
+		{"only proxies", "10.0.0.2:5000", []string{"10.0.0.3"}, "10.0.0.2"},
+		{"IPv6 proxy and client", "[fd00::2]:5000", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"IPv6 direct", "[2001:db8::7]:5000", nil, "2001:db8::7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := ClientIP(r, trusted); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		in         http.Header
+		prefix     string
+		want       map[string]string
+	}{
+		{
+			name:       "direct IPv4 client",
+			remoteAddr: "203.0.113.7:5000",
+			in:         http.Header{"X-Forwarded-For": {"1.2.3.4"}, "Forwarded": {"for=1.2.3.4"}},
+			prefix:     "/app",
+			want: map[string]string{
+				"X-Forwarded-For":    "203.0.113.7",
+				"X-Forwarded-Host":   "tunnel.example.com",
+				"X-Forwarded-Proto":  "http",
+				"X-Forwarded-Prefix": "/app",
+				"X-Real-Ip":          "203.0.113.7",
+				"Forwarded":          "for=203.0.113.7;host=tunnel.example.com;proto=http",
+			},
+		},
+		{
+			name:       "direct IPv6 client is quoted and bracketed",
+			remoteAddr: "[2001:db8::7]:5000",
+			in:         http.Header{},
+			want: map[string]string{
+				"X-Forwarded-For": "2001:db8::7",
+				"X-Real-Ip":       "2001:db8::7",
+				"Forwarded":       `for="[2001:db8::7]";host=tunnel.example.com;proto=http`,
+			},
+		},
+		{
+			name:       "trusted proxy chain is extended",
+			remoteAddr: "10.0.0.2:5000",
+			in: http.Header{
+				"X-Forwarded-For":    {"198.51.100.1"},
+				"X-Forwarded-Proto":  {"https"},
+				"X-Forwarded-Host":   {"public.example.com"},
+				"X-Forwarded-Prefix": {"/edge/"},
+				"Forwarded":          {`for="[2001:db8::1]";proto=https`},
+			},
+			prefix: "/app",
+			want: map[string]string{
+				"X-Forwarded-For":    "198.51.100.1, 10.0.0.2",
+				"X-Forwarded-Host":   "public.example.com",
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Prefix": "/edge/app",
+				"X-Real-Ip":          "198.51.100.1",
+				"Forwarded":          `for="[2001:db8::1]";proto=https, for=10.0.0.2;host=tunnel.example.com;proto=https`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://tunnel.example.com/app/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header = tt.in.Clone()
+			headers := tt.in.Clone()
+			SetForwardedHeaders(headers, r, tt.prefix, trusted)
+			for name, want := range tt.want {
+				if got := headers.Values(name); len(got) != 1 || got[0] != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStripForwardedHeaders(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr string
+		wantKept   bool
+	}{
+		{"203.0.113.7:5000", false},
+		{"10.0.0.2:5000", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		headers := http.Header{"X-Forwarded-For": {"1.2.3.4"}, "Forwarded": {"for=1.2.3.4"}, "Accept": {"*/*"}}
+		StripForwardedHeaders(headers, r, trusted)
+		if kept := headers.Get("X-Forwarded-For") != "" && headers.Get("Forwarded") != ""; kept != tt.wantKept {
+			t.Errorf("from %s: forwarding headers kept = %v, want %v", tt.remoteAddr, kept, tt.wantKept)
+		}
+		if headers.Get("Accept") == "" {
+			t.Errorf("from %s: Accept was removed", tt.remoteAddr)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/B-AJ-Amar/gTunnel/internal/har"
+	version "github.com/B-AJ-Amar/gTunnel/internal/pkg"
+	"github.com/B-AJ-Amar/gTunnel/internal/server/models"
+)
+
+// OpenTunnelHAR creates the HAR file recording a tunnel's traffic: <dir>/<base_url>-<timestamp>.har
+func OpenTunnelHAR(cfg models.HARConfig, baseURL string) (*har.Writer, error) {
+	name := fmt.Sprintf("%s-%s.har", strings.ReplaceAll(baseURL, "/", "_"), time.Now().Format("20060102-150405"))
+	return har.Create(filepath.Join(cfg.Dir, name), har.Creator{Name: "gTunnel server", Version: version.GetVersion()}, har.Options{
+		MaxBodySize:   cfg.MaxBodySize,
+		RedactHeaders: cfg.RedactHeaders,
+	})
+}
@@ -28,7 +28,7 @@ func PathTunnelRouter(r *http.Request, connections map[string]*models.ServerTunn
 
 	for _, conn := range connections {
 		if conn.BaseURL == appID {
-			return conn, appID, endpoint
+			return conn, appID, conn.Paths.Path(r.URL.Path, endpoint)
 		}
 	}
 	return nil, "", ""